@@ -2,21 +2,78 @@ package SLog
 
 import (
 	"fmt"
-	"github.com/logrusorgru/aurora"
-	"log"
+	"time"
 )
 
 const logBaseFormat = "%40v | %s"
 
 type Instance struct {
-	scope string
+	scope   string
+	fields  []Field
+	limiter *rateLimiter
 }
 
-func (i *Instance) Log(str interface{}, v ...interface{}) *Instance {
-	if infoEnabled {
-		var c = aurora.Cyan
-		log.Printf(logBaseFormat, c(aurora.Bold(i.scope)).String(), c(fmt.Sprintf(asString(str), v...)))
+// With returns an Instance of the same scope that adds key=value to every message
+func (i *Instance) With(key string, value interface{}) *Instance {
+	var fields = make([]Field, len(i.fields), len(i.fields)+1)
+	copy(fields, i.fields)
+
+	return &Instance{
+		scope:   i.scope,
+		fields:  append(fields, Field{Key: key, Value: value}),
+		limiter: i.limiter,
+	}
+}
+
+// Scope returns an Instance of another scope with the same fields
+func (i *Instance) Scope(scope string) *Instance {
+	return &Instance{
+		scope:   scope,
+		fields:  i.fields,
+		limiter: i.limiter,
+	}
+}
+
+func (i *Instance) log(level Level, str interface{}, v ...interface{}) {
+	var enabled = isEnabled(i.scope, level)
+	var toSinks = sinksFor(i.scope, level)
+	if !enabled && len(toSinks) == 0 {
+		return
+	}
+
+	var now = time.Now()
+	var format = asString(str)
+	var fields = i.fields
+
+	if i.limiter != nil {
+		allowed, suppressed := i.limiter.allow(format, now)
+		if !allowed {
+			return
+		}
+		if suppressed > 0 {
+			fields = append(append([]Field{}, fields...), Field{Key: "suppressed", Value: suppressed})
+		}
 	}
+
+	var e = entry{
+		time:    now,
+		level:   level,
+		scope:   i.scope,
+		message: fmt.Sprintf(format, v...),
+		fields:  fields,
+	}
+
+	if enabled {
+		write(e)
+	}
+
+	for _, sink := range toSinks {
+		_, _ = sink.Writer.Write(e.format(sink.Format))
+	}
+}
+
+func (i *Instance) Log(str interface{}, v ...interface{}) *Instance {
+	i.log(LevelInfo, str, v...)
 	return i
 }
 
@@ -25,29 +82,22 @@ func (i *Instance) Info(str interface{}, v ...interface{}) *Instance {
 }
 
 func (i *Instance) Debug(str interface{}, v ...interface{}) *Instance {
-	if debugEnabled {
-		var c = aurora.Magenta
-		log.Printf(logBaseFormat, c(aurora.Bold(i.scope)).String(), c(fmt.Sprintf(asString(str), v...)))
-	}
+	i.log(LevelDebug, str, v...)
 	return i
 }
 
 func (i *Instance) Warn(str interface{}, v ...interface{}) *Instance {
-	if warnEnabled {
-		var c = aurora.Brown
-		log.Printf(logBaseFormat, c(aurora.Bold(i.scope)).String(), c(fmt.Sprintf(asString(str), v...)))
-	}
+	i.log(LevelWarn, str, v...)
 	return i
 }
 
 func (i *Instance) Error(str interface{}, v ...interface{}) *Instance {
-	if errorEnabled {
-		var c = aurora.Red
-		log.Printf(logBaseFormat, c(aurora.Bold(i.scope)).String(), c(fmt.Sprintf(asString(str), v...)))
-	}
+	i.log(LevelError, str, v...)
 	return i
 }
 
+// Fatal is always written, then the server exits
 func (i *Instance) Fatal(str interface{}, v ...interface{}) {
-	log.Fatalf(asString(str), v...)
+	i.log(LevelFatal, str, v...)
+	exit(1)
 }
@@ -0,0 +1,162 @@
+package SLog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/logrusorgru/aurora"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const textTimeFormat = "2006/01/02 15:04:05"
+
+// Format is how messages are written
+type Format int
+
+const (
+	// FormatAuto writes colored text on a terminal and plain text otherwise
+	FormatAuto Format = iota
+	// FormatText writes colored text
+	FormatText
+	// FormatPlain writes text without colors
+	FormatPlain
+	// FormatJSON writes one JSON object per line, for log aggregation
+	FormatJSON
+)
+
+var formatNames = map[string]Format{
+	"auto":  FormatAuto,
+	"text":  FormatText,
+	"plain": FormatPlain,
+	"json":  FormatJSON,
+}
+
+// ParseFormat parses auto, text, plain or json. An empty name is auto.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatAuto, nil
+	}
+
+	format, ok := formatNames[strings.ToLower(name)]
+	if !ok {
+		return FormatAuto, fmt.Errorf("invalid log format %q", name)
+	}
+
+	return format, nil
+}
+
+// Field is a key and value added to every message of an Instance
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+type entry struct {
+	time    time.Time
+	level   Level
+	scope   string
+	message string
+	fields  []Field
+}
+
+var levelColors = map[Level]func(interface{}) aurora.Value{
+	LevelDebug: aurora.Magenta,
+	LevelInfo:  aurora.Cyan,
+	LevelWarn:  aurora.Brown,
+	LevelError: aurora.Red,
+	LevelFatal: aurora.Red,
+}
+
+// isTerminal returns true if w is a character device, like a TTY
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (e entry) format(format Format) []byte {
+	if format == FormatJSON {
+		return e.formatJSON()
+	}
+
+	return e.formatText(format == FormatText)
+}
+
+func (e entry) formatText(colored bool) []byte {
+	var buff = bytes.NewBuffer(nil)
+	buff.WriteString(e.time.Format(textTimeFormat))
+	buff.WriteString(" ")
+
+	var message = e.message
+	for _, f := range e.fields {
+		message += " " + f.Key + "=" + textValue(f.Value)
+	}
+
+	if colored {
+		var c = levelColors[e.level]
+		_, _ = fmt.Fprintf(buff, logBaseFormat, c(aurora.Bold(e.scope)).String(), c(message))
+	} else {
+		_, _ = fmt.Fprintf(buff, logBaseFormat, e.scope, message)
+	}
+
+	buff.WriteString("\n")
+
+	return buff.Bytes()
+}
+
+// textValue quotes values that would be ambiguous in key=value form
+func textValue(value interface{}) string {
+	var s = fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func (e entry) formatJSON() []byte {
+	var buff = bytes.NewBuffer(nil)
+	buff.WriteString("{")
+	writeJSONField(buff, "time", e.time.Format(time.RFC3339Nano), true)
+	writeJSONField(buff, "level", e.level.String(), false)
+	writeJSONField(buff, "scope", e.scope, false)
+	writeJSONField(buff, "message", e.message, false)
+	for _, f := range e.fields {
+		writeJSONField(buff, f.Key, f.Value, false)
+	}
+	buff.WriteString("}\n")
+
+	return buff.Bytes()
+}
+
+func writeJSONField(buff *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		buff.WriteString(",")
+	}
+
+	k, _ := json.Marshal(key)
+	buff.Write(k)
+	buff.WriteString(":")
+
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buff.Write(v)
+}
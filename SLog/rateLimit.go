@@ -0,0 +1,56 @@
+package SLog
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter lets each message through at most once every interval. Messages are told apart by their format
+// string, so the arguments can change between them.
+type rateLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	messages map[string]*limitedMessage
+}
+
+type limitedMessage struct {
+	lastWritten time.Time
+	suppressed  int
+}
+
+// allow returns true if the message can be written now, and how many times it was suppressed since the last time
+func (l *rateLimiter) allow(message string, now time.Time) (bool, int) {
+	l.Lock()
+	defer l.Unlock()
+
+	var m, ok = l.messages[message]
+	if !ok {
+		m = &limitedMessage{}
+		l.messages[message] = m
+	}
+
+	if !m.lastWritten.IsZero() && now.Sub(m.lastWritten) < l.interval {
+		m.suppressed++
+		return false, 0
+	}
+
+	var suppressed = m.suppressed
+	m.lastWritten = now
+	m.suppressed = 0
+
+	return true, suppressed
+}
+
+// RateLimited returns an Instance of the same scope and fields that writes each message at most once every interval,
+// for messages that can repeat many times per second. The number of messages dropped in between is added to the
+// next one as the suppressed field.
+func (i *Instance) RateLimited(interval time.Duration) *Instance {
+	return &Instance{
+		scope:  i.scope,
+		fields: i.fields,
+		limiter: &rateLimiter{
+			interval: interval,
+			messages: map[string]*limitedMessage{},
+		},
+	}
+}
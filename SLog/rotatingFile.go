@@ -0,0 +1,160 @@
+package SLog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const rotatedTimeFormat = "20060102-150405.000"
+
+// rename is replaced in tests
+var rename = os.Rename
+
+// RotatingFile is a log file that is renamed and started again when it gets bigger than maxSize or older than maxAge.
+// Rotated files get the time of the rotation appended to their name.
+type RotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// OpenRotatingFile opens (or creates) path to append messages to it. 0 disables maxSize and maxAge, and maxBackups
+// is the number of rotated files to keep (0 keeps them all).
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	var f = &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+
+	return nil
+}
+
+func (f *RotatingFile) Write(data []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	var tooBig = f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize
+	var tooOld = f.maxAge > 0 && time.Since(f.openedAt) >= f.maxAge
+	var rotateErr error
+	if f.file != nil && (tooBig || tooOld) {
+		rotateErr = f.rotate()
+	}
+
+	if f.file == nil {
+		// The file could not be opened again after the last rotation
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+
+	if err == nil {
+		err = rotateErr
+	}
+
+	return n, err
+}
+
+// rotate renames the file and starts a new one. If the file can't be renamed, messages keep going to it.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	err = rename(f.path, f.path+"."+time.Now().Format(rotatedTimeFormat))
+	if err != nil {
+		openErr := f.open()
+		if openErr != nil {
+			return openErr
+		}
+		// Tried again after another maxSize bytes or maxAge, not with every message
+		f.size = 0
+		return err
+	}
+
+	f.removeOldBackups()
+
+	return f.open()
+}
+
+// removeOldBackups keeps the last maxBackups rotated files
+func (f *RotatingFile) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+
+	// Only the files named by rotate
+	var backups = make([]string, 0, len(matches))
+	for _, v := range matches {
+		_, err := time.Parse(rotatedTimeFormat, v[len(f.path)+1:])
+		if err == nil {
+			backups = append(backups, v)
+		}
+	}
+
+	if len(backups) <= f.maxBackups {
+		return
+	}
+
+	// The time format sorts by name
+	sort.Strings(backups)
+	for _, v := range backups[:len(backups)-f.maxBackups] {
+		_ = os.Remove(v)
+	}
+}
+
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	return f.file.Close()
+}
@@ -0,0 +1,73 @@
+package SLog
+
+import (
+	"io"
+	"sync"
+)
+
+// Sink is a destination of messages besides the main output, like a log file. Sinks have their own format and level:
+// the global and scope levels only apply to the main output.
+type Sink struct {
+	Writer io.Writer
+	Format Format
+	// Level is the minimum level written
+	Level Level
+	// Scopes only writes the messages of these scopes. Empty writes every scope.
+	Scopes []string
+}
+
+var sinksMtx = sync.RWMutex{}
+var sinks []*Sink
+
+// AddSink writes every message accepted by s to it
+func AddSink(s *Sink) {
+	sinksMtx.Lock()
+	sinks = append(sinks, s)
+	sinksMtx.Unlock()
+}
+
+// RemoveSink stops writing to s. It's not closed.
+func RemoveSink(s *Sink) {
+	sinksMtx.Lock()
+	defer sinksMtx.Unlock()
+
+	for i, v := range sinks {
+		if v == s {
+			sinks = append(sinks[:i], sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Sink) accepts(scope string, level Level) bool {
+	if level < s.Level {
+		return false
+	}
+
+	if len(s.Scopes) == 0 {
+		return true
+	}
+
+	for _, v := range s.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sinksFor returns the sinks that accept a message
+func sinksFor(scope string, level Level) []*Sink {
+	sinksMtx.RLock()
+	defer sinksMtx.RUnlock()
+
+	var accepted []*Sink
+	for _, s := range sinks {
+		if s.accepts(scope, level) {
+			accepted = append(accepted, s)
+		}
+	}
+
+	return accepted
+}
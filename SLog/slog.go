@@ -1,11 +1,28 @@
 package SLog
 
+import (
+	"io"
+	"os"
+	"sync"
+)
+
 // region Global
 var debugEnabled = true
 var warnEnabled = true
 var errorEnabled = true
 var infoEnabled = true
 
+var settingsMtx = sync.RWMutex{}
+var globalLevel = LevelInfo
+var scopeLevels = map[string]Level{}
+
+var outputMtx = sync.Mutex{}
+var output io.Writer = os.Stderr
+var outputFormat = FormatAuto
+
+// exit is replaced in tests
+var exit = os.Exit
+
 var glog = Instance{scope: "RadioServer"}
 
 func Log(str interface{}, v ...interface{}) *Instance {
@@ -29,7 +46,7 @@ func Error(str interface{}, v ...interface{}) *Instance {
 }
 
 func Fatal(str interface{}, v ...interface{}) {
-	glog.Fatal(str, v)
+	glog.Fatal(str, v...)
 }
 
 func Scope(scope string) *Instance {
@@ -39,16 +56,125 @@ func Scope(scope string) *Instance {
 }
 
 func SetDebug(enabled bool) {
+	settingsMtx.Lock()
 	debugEnabled = enabled
+	settingsMtx.Unlock()
 }
 func SetWarning(enabled bool) {
+	settingsMtx.Lock()
 	warnEnabled = enabled
+	settingsMtx.Unlock()
 }
 func SetInfo(enabled bool) {
+	settingsMtx.Lock()
 	infoEnabled = enabled
+	settingsMtx.Unlock()
 }
 func SetError(enabled bool) {
+	settingsMtx.Lock()
 	errorEnabled = enabled
+	settingsMtx.Unlock()
+}
+
+// SetLevel sets the minimum level of the scopes without their own level
+func SetLevel(level Level) {
+	settingsMtx.Lock()
+	globalLevel = level
+	settingsMtx.Unlock()
+}
+
+// SetScopeLevel sets the minimum level of a scope, overriding the global one
+func SetScopeLevel(scope string, level Level) {
+	settingsMtx.Lock()
+	scopeLevels[scope] = level
+	settingsMtx.Unlock()
+}
+
+// ClearScopeLevel makes the scope use the global level again
+func ClearScopeLevel(scope string) {
+	settingsMtx.Lock()
+	delete(scopeLevels, scope)
+	settingsMtx.Unlock()
+}
+
+// GetGlobalLevel returns the minimum level of the scopes without their own level
+func GetGlobalLevel() Level {
+	settingsMtx.RLock()
+	defer settingsMtx.RUnlock()
+
+	return globalLevel
+}
+
+// GetLevel returns the minimum level of a scope
+func GetLevel(scope string) Level {
+	settingsMtx.RLock()
+	defer settingsMtx.RUnlock()
+
+	if level, ok := scopeLevels[scope]; ok {
+		return level
+	}
+
+	return globalLevel
+}
+
+// GetScopeLevels returns the scopes that have their own level
+func GetScopeLevels() map[string]Level {
+	settingsMtx.RLock()
+	defer settingsMtx.RUnlock()
+
+	var levels = make(map[string]Level, len(scopeLevels))
+	for scope, level := range scopeLevels {
+		levels[scope] = level
+	}
+
+	return levels
+}
+
+// SetOutput changes where messages are written (stderr by default)
+func SetOutput(w io.Writer) {
+	outputMtx.Lock()
+	output = w
+	outputMtx.Unlock()
+}
+
+// SetFormat changes how messages are written
+func SetFormat(format Format) {
+	outputMtx.Lock()
+	outputFormat = format
+	outputMtx.Unlock()
+}
+
+func isEnabled(scope string, level Level) bool {
+	settingsMtx.RLock()
+	var enabled = true
+	switch level {
+	case LevelDebug:
+		enabled = debugEnabled
+	case LevelInfo:
+		enabled = infoEnabled
+	case LevelWarn:
+		enabled = warnEnabled
+	case LevelError:
+		enabled = errorEnabled
+	}
+	settingsMtx.RUnlock()
+
+	return enabled && level >= GetLevel(scope)
+}
+
+func write(e entry) {
+	outputMtx.Lock()
+	defer outputMtx.Unlock()
+
+	var format = outputFormat
+	if format == FormatAuto {
+		format = FormatPlain
+		if isTerminal(output) {
+			format = FormatText
+		}
+	}
+
+	_, _ = output.Write(e.format(format))
 }
 
 // endregion
@@ -0,0 +1,261 @@
+package SLog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureOutput(t *testing.T, format Format) *bytes.Buffer {
+	var buff = bytes.NewBuffer(nil)
+	SetOutput(buff)
+	SetFormat(format)
+	SetLevel(LevelDebug)
+
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		SetFormat(FormatAuto)
+		SetLevel(LevelInfo)
+		for scope := range GetScopeLevels() {
+			ClearScopeLevel(scope)
+		}
+	})
+
+	return buff
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buff = captureOutput(t, FormatJSON)
+
+	Scope("Client").With("uuid", "1234").With("port", 5555).Warn("Disconnecting: %s", errors.New("timeout"))
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buff.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("expected a JSON line got %q: %s", buff.String(), err)
+	}
+
+	var expected = map[string]interface{}{
+		"level":   "warn",
+		"scope":   "Client",
+		"message": "Disconnecting: timeout",
+		"uuid":    "1234",
+		"port":    float64(5555),
+	}
+	for k, v := range expected {
+		if line[k] != v {
+			t.Errorf("expected %s to be %v got %v", k, v, line[k])
+		}
+	}
+
+	if _, ok := line["time"]; !ok {
+		t.Errorf("expected a time field")
+	}
+}
+
+func TestPlainFormat(t *testing.T) {
+	var buff = captureOutput(t, FormatAuto)
+
+	Scope("Client").With("address", "127.0.0.1:1234").With("name", "SDR Console").Info("Connected")
+
+	var out = buff.String()
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("expected no colors when not writing to a terminal got %q", out)
+	}
+
+	if !strings.HasSuffix(out, `Client | Connected address=127.0.0.1:1234 name="SDR Console"`+"\n") {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestScopeLevels(t *testing.T) {
+	var buff = captureOutput(t, FormatPlain)
+
+	SetLevel(LevelInfo)
+	SetScopeLevel("Noisy", LevelError)
+	SetScopeLevel("Verbose", LevelDebug)
+
+	Scope("Other").Debug("hidden")
+	Scope("Other").Info("shown 1")
+	Scope("Noisy").Warn("hidden")
+	Scope("Noisy").Error("shown 2")
+	Scope("Verbose").Debug("shown 3")
+
+	var out = buff.String()
+	if strings.Contains(out, "hidden") || strings.Count(out, "shown") != 3 {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	ClearScopeLevel("Noisy")
+	if GetLevel("Noisy") != LevelInfo {
+		t.Errorf("expected the scope to use the global level again")
+	}
+}
+
+func TestFatal(t *testing.T) {
+	var buff = captureOutput(t, FormatPlain)
+
+	var exitCode = -1
+	exit = func(code int) { exitCode = code }
+	defer func() { exit = os.Exit }()
+
+	SetScopeLevel("RadioServer", LevelError)
+	Fatal("Error loading %s: %s", "config.json", errors.New("not found"))
+
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1 got %d", exitCode)
+	}
+
+	if !strings.Contains(buff.String(), "RadioServer | Error loading config.json: not found") {
+		t.Errorf("unexpected output %q", buff.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": LevelDebug, "Info": LevelInfo, "warning": LevelWarn, "error": LevelError} {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("expected %s to be %s got %s (%v)", name, expected, level, err)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expected an error for an invalid level")
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var main = captureOutput(t, FormatPlain)
+	SetLevel(LevelError)
+
+	var all = &Sink{Writer: bytes.NewBuffer(nil), Format: FormatPlain, Level: LevelDebug}
+	var audit = &Sink{Writer: bytes.NewBuffer(nil), Format: FormatJSON, Level: LevelDebug, Scopes: []string{"Audit"}}
+	AddSink(all)
+	AddSink(audit)
+	defer RemoveSink(all)
+	defer RemoveSink(audit)
+
+	var client = Scope("Client").With("uuid", "1234")
+	client.Debug("ping")
+	client.Scope("Audit").Info("Client connected")
+
+	if main.Len() != 0 {
+		t.Errorf("expected nothing in the main output got %q", main.String())
+	}
+
+	var allOut = all.Writer.(*bytes.Buffer).String()
+	if strings.Count(allOut, "\n") != 2 {
+		t.Errorf("expected both messages in the sink without scopes got %q", allOut)
+	}
+
+	var line map[string]interface{}
+	err := json.Unmarshal(audit.Writer.(*bytes.Buffer).Bytes(), &line)
+	if err != nil || line["scope"] != "Audit" || line["uuid"] != "1234" {
+		t.Errorf("expected only the audit message with the client fields got %v (%v)", line, err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog")
+	if err != nil {
+		t.Fatalf("error creating the directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "radioserver.log")
+
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("error opening the file: %s", err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = f.Write([]byte("12345678\n"))
+		if err != nil {
+			t.Fatalf("error writing: %s", err)
+		}
+		// Rotated files are named by time
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("expected 2 rotated files got %v", backups)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "12345678\n" {
+		t.Errorf("expected the last message in the current file got %q", data)
+	}
+}
+
+func TestRotatingFileRenameError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slog")
+	if err != nil {
+		t.Fatalf("error creating the directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "radioserver.log")
+
+	rename = func(string, string) error { return errors.New("rename failed") }
+	defer func() { rename = os.Rename }()
+
+	f, err := OpenRotatingFile(path, 20, 0, 2)
+	if err != nil {
+		t.Fatalf("error opening the file: %s", err)
+	}
+	defer f.Close()
+
+	_, _ = f.Write([]byte("12345678\n"))
+	_, _ = f.Write([]byte("12345678\n"))
+	n, err := f.Write([]byte("12345678\n"))
+	if n != 9 || err == nil {
+		t.Errorf("expected the message to be written with the rename error got %d (%v)", n, err)
+	}
+
+	// Not rotated again until it grows another maxSize bytes
+	_, err = f.Write([]byte("12345678\n"))
+	if err != nil {
+		t.Errorf("expected no rotation got %s", err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != strings.Repeat("12345678\n", 4) {
+		t.Errorf("expected every message in the file got %q", data)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	var buff = captureOutput(t, FormatJSON)
+
+	var log = Scope("ChannelGenerator").RateLimited(time.Hour)
+	for i := 0; i < 10; i++ {
+		log.Warn("Fifo overflowing, dropping samples")
+		log.Warn("Client %d is slow", i)
+	}
+
+	var lines = strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected each message once got %q", lines)
+	}
+
+	// The next message that gets through reports the ones that were dropped
+	var limiter = log.limiter
+	for _, m := range limiter.messages {
+		m.lastWritten = m.lastWritten.Add(-time.Hour)
+	}
+	buff.Reset()
+	log.Warn("Fifo overflowing, dropping samples")
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buff.Bytes(), &line)
+	if err != nil || line["suppressed"] != float64(9) {
+		t.Errorf("expected 9 suppressed messages got %v (%v)", line, err)
+	}
+}
@@ -0,0 +1,132 @@
+package StateModels
+
+import (
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+)
+
+// BandwidthLimits are the output bandwidth caps that apply to a client, in bytes per second. 0 means unlimited.
+type BandwidthLimits struct {
+	MaxClientBandwidth   uint64
+	MaxListenerBandwidth uint64
+	MaxServerBandwidth   uint64
+
+	// Listener identifies the clients that share MaxListenerBandwidth
+	Listener string
+
+	// Reject refuses settings above the limits instead of raising the decimation
+	Reject bool
+}
+
+// iqBandwidth returns the IQ output in bytes per second for the specified decimation stage and format
+func (state *ClientState) iqBandwidth(decimation, format uint32) uint64 {
+	var sampleRate = state.ServerState.DeviceSampleRate() / tools.StageToNumber(decimation)
+	return uint64(sampleRate) * uint64(tools.IQFormatSampleSize(format))
+}
+
+// currentBandwidth returns the IQ output in bytes per second of a streaming client
+func (state *ClientState) currentBandwidth() uint64 {
+	var cgs = state.GetCGS()
+	if !cgs.Streaming || (cgs.StreamingMode&protocol.StreamTypeIQ) == 0 {
+		return 0
+	}
+
+	return state.iqBandwidth(cgs.IQDecimation, cgs.IQFormat)
+}
+
+// availableBandwidth returns how much bandwidth this client can use considering all limits and other clients
+func (state *ClientState) availableBandwidth() uint64 {
+	var limits = state.Bandwidth
+	var available = limits.MaxClientBandwidth
+
+	if limits.MaxListenerBandwidth == 0 && limits.MaxServerBandwidth == 0 {
+		return available
+	}
+
+	var listenerUsage = uint64(0)
+	var serverUsage = uint64(0)
+
+	for _, v := range state.ServerState.GetClients() {
+		if v.UUID == state.UUID {
+			continue
+		}
+
+		var usage = v.currentBandwidth()
+		serverUsage += usage
+		if v.Bandwidth.Listener == limits.Listener {
+			listenerUsage += usage
+		}
+	}
+
+	available = minLimit(available, remainingBandwidth(limits.MaxListenerBandwidth, listenerUsage))
+	available = minLimit(available, remainingBandwidth(limits.MaxServerBandwidth, serverUsage))
+
+	return available
+}
+
+// minLimit returns the smallest limit, 0 being unlimited
+func minLimit(a, b uint64) uint64 {
+	if a == 0 {
+		return b
+	}
+
+	if b == 0 || a < b {
+		return a
+	}
+
+	return b
+}
+
+// remainingBandwidth returns what is left from a limit (0 being unlimited). If nothing is left it returns 1 so the
+// result is still a limit.
+func remainingBandwidth(limit, usage uint64) uint64 {
+	if limit == 0 {
+		return 0
+	}
+
+	if usage >= limit {
+		return 1
+	}
+
+	return limit - usage
+}
+
+// minimumIQDecimation returns the smallest decimation stage that fits the bandwidth limits for the specified format.
+// Returns false if not even the highest decimation fits.
+func (state *ClientState) minimumIQDecimation(format uint32) (uint32, bool) {
+	var available = state.availableBandwidth()
+	if available == 0 {
+		return 0, true
+	}
+
+	var stageCount = state.ServerState.GetDeviceInfo().DecimationStageCount
+	for decimation := uint32(0); decimation <= stageCount; decimation++ {
+		if state.iqBandwidth(decimation, format) <= available {
+			return decimation, true
+		}
+	}
+
+	return stageCount, false
+}
+
+// enforceBandwidth returns the decimation to use for the specified settings. If the limits are exceeded it either
+// raises the decimation or, if the client is configured to reject, returns false.
+func (state *ClientState) enforceBandwidth(decimation, format uint32) (uint32, bool) {
+	var minimumDecimation, ok = state.minimumIQDecimation(format)
+	if !ok {
+		state.Warn("No decimation fits the bandwidth limits for IQ format %d", format)
+		return decimation, false
+	}
+
+	if decimation >= minimumDecimation {
+		return decimation, true
+	}
+
+	if state.Bandwidth.Reject {
+		state.Warn("Rejecting IQ decimation %d: exceeds bandwidth limits (minimum is %d)", decimation, minimumDecimation)
+		return decimation, false
+	}
+
+	state.Warn("IQ decimation %d exceeds bandwidth limits. Using %d", decimation, minimumDecimation)
+	return minimumDecimation, true
+}
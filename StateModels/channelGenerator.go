@@ -12,6 +12,9 @@ import (
 
 var cgLog = SLog.Scope("ChannelGenerator")
 
+// cgOverflowLog is limited since the FIFO overflows on every sample batch while a client can't keep up
+var cgOverflowLog = cgLog.RateLimited(5 * time.Second)
+
 const maxFifoSize = 4096
 
 type OnFFTSamples func(samples []float32)
@@ -22,9 +25,12 @@ type ChannelGenerator struct {
 	fftFrequencyTranslator *dsp.FrequencyTranslator
 
 	inputFifo     *fifo.Queue
-	running       bool
 	settingsMutex sync.Mutex
 
+	// running is changed by the client goroutine and read by the routine and the frontend goroutine
+	running    bool
+	runningMtx sync.RWMutex
+
 	fftEnabled bool
 	iqEnabled  bool
 
@@ -45,22 +51,26 @@ func CreateChannelGenerator() *ChannelGenerator {
 
 func (cg *ChannelGenerator) routine() {
 	defer cg.waitAll()
-	for cg.running {
+	for cg.isRunning() {
 		select {
 		case <-cg.updateChannel:
-			if !cg.running {
+			if !cg.isRunning() {
 				break
 			}
 			cg.doWork()
 		case <-time.After(1 * time.Second):
 
 		}
-		if !cg.running {
-			break
-		}
 	}
 }
 
+func (cg *ChannelGenerator) isRunning() bool {
+	cg.runningMtx.RLock()
+	defer cg.runningMtx.RUnlock()
+
+	return cg.running
+}
+
 func (cg *ChannelGenerator) waitAll() {
 	var pending = true
 	cgLog.Debug("Waiting for all pending to process")
@@ -124,6 +134,9 @@ func (cg *ChannelGenerator) notify() {
 }
 
 func (cg *ChannelGenerator) Start() {
+	cg.runningMtx.Lock()
+	defer cg.runningMtx.Unlock()
+
 	if !cg.running {
 		cgLog.Info("Starting Channel Generator")
 		if cg.iqFrequencyTranslator == nil && cg.fftFrequencyTranslator == nil {
@@ -135,9 +148,13 @@ func (cg *ChannelGenerator) Start() {
 }
 
 func (cg *ChannelGenerator) Stop() {
-	if cg.running {
+	cg.runningMtx.Lock()
+	var wasRunning = cg.running
+	cg.running = false
+	cg.runningMtx.Unlock()
+
+	if wasRunning {
 		cgLog.Info("Stopping")
-		cg.running = false
 		cg.notify()
 	}
 }
@@ -146,43 +163,42 @@ func (cg *ChannelGenerator) UpdateSettings(state *ClientState) {
 	cg.settingsMutex.Lock()
 	cgLog.Info("Updating settings")
 
-	var deviceFrequency = state.ServerState.Frontend.GetCenterFrequency()
-	var deviceSampleRate = state.ServerState.Frontend.GetSampleRate()
+	var deviceFrequency = state.ServerState.DeviceCenterFrequency()
+	var deviceSampleRate = state.ServerState.DeviceSampleRate()
+	var cgs = state.GetCGS()
 
-	cg.iqEnabled = (state.CGS.StreamingMode & protocol.StreamTypeIQ) > 0
-	cg.fftEnabled = (state.CGS.StreamingMode & protocol.StreamTypeFFT) > 0
+	cg.iqEnabled = (cgs.StreamingMode & protocol.StreamTypeIQ) > 0
+	cg.fftEnabled = (cgs.StreamingMode & protocol.StreamTypeFFT) > 0
 
 	// region IQ Channel
 	if cg.iqEnabled {
-		var iqDecimationNumber = tools.StageToNumber(state.CGS.IQDecimation)
+		var iqDecimationNumber = tools.StageToNumber(cgs.IQDecimation)
 		var iqFtTaps = tools.GenerateTranslatorTaps(iqDecimationNumber, deviceSampleRate)
-		var iqDeltaFrequency = float32(state.CGS.IQCenterFrequency) - float32(deviceFrequency)
+		var iqDeltaFrequency = float32(cgs.IQCenterFrequency) - float32(deviceFrequency)
 		cgLog.Debug("IQ Delta Frequency: %.0f", iqDeltaFrequency)
 		cg.iqFrequencyTranslator = dsp.MakeFrequencyTranslator(int(iqDecimationNumber), iqDeltaFrequency, float32(deviceSampleRate), iqFtTaps)
 	}
 	// endregion
 	// region FFT Channel
 	if cg.fftEnabled {
-		var fftDecimationNumber = tools.StageToNumber(state.CGS.FFTDecimation)
+		var fftDecimationNumber = tools.StageToNumber(cgs.FFTDecimation)
 		var fftFtTaps = tools.GenerateTranslatorTaps(fftDecimationNumber, deviceSampleRate)
-		var fftDeltaFrequency = float32(state.CGS.FFTCenterFrequency) - float32(deviceFrequency)
+		var fftDeltaFrequency = float32(cgs.FFTCenterFrequency) - float32(deviceFrequency)
 		cgLog.Debug("FFT Delta Frequency: %.0f", fftDeltaFrequency)
 		cg.fftFrequencyTranslator = dsp.MakeFrequencyTranslator(int(fftDecimationNumber), fftDeltaFrequency, float32(deviceSampleRate), fftFtTaps)
 	}
 	// endregion
 	cg.settingsMutex.Unlock()
-	if state.CGS.Streaming && !cg.running {
+	if cgs.Streaming {
 		cg.Start()
-	}
-
-	if !state.CGS.Streaming && cg.running {
+	} else {
 		cg.Stop()
 	}
 	cgLog.Info("Settings updated.")
 }
 
 func (cg *ChannelGenerator) PushSamples(samples []complex64) {
-	if !cg.running {
+	if !cg.isRunning() {
 		return
 	}
 
@@ -190,7 +206,7 @@ func (cg *ChannelGenerator) PushSamples(samples []complex64) {
 	var fifoLength = cg.inputFifo.UnsafeLen()
 
 	if maxFifoSize <= fifoLength {
-		cgLog.Debug("Fifo Overflowing!")
+		cgOverflowLog.Warn("Fifo overflowing, dropping samples")
 		cg.inputFifo.UnsafeUnlock()
 		return
 	}
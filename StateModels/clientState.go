@@ -1,6 +1,7 @@
 package StateModels
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/racerxdl/radioserver/SLog"
 	"github.com/racerxdl/radioserver/protocol"
@@ -8,6 +9,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,7 +41,7 @@ type ClientState struct {
 	LogInstance    *SLog.Instance
 	Addr           net.Addr
 	Conn           net.Conn
-	Running        bool
+	running        int32
 	Name           string
 	ClientVersion  protocol.Version
 	CurrentState   int
@@ -56,29 +58,45 @@ type ClientState struct {
 	Cmd            protocol.CommandHeader
 	CmdBody        []uint8
 	ParserPosition uint32
-	SyncInfo       protocol.ClientSync
+	// SyncInfo is guarded by cgsMtx. Other goroutines read it with GetSyncInfo.
+	SyncInfo protocol.ClientSync
 
-	LastPingTime int64
+	LastPingTime     int64
+	LastPingReceived time.Time
+	LastCommandTime  time.Time
+	ProtocolErrors   int
+
+	Timeouts         ClientTimeouts
+	DisconnectReason string
+	reasonMtx        sync.Mutex
 
 	// Channel Generator
 	CGS ChannelGeneratorState
 	CG  *ChannelGenerator
+	// cgsMtx is held to change CGS. Other goroutines (the channel generator, other clients, the watchdog) change it
+	// too, so reads outside of it go through GetCGS. It's taken before the ServerState device lock, never after.
+	cgsMtx sync.RWMutex
+
+	Bandwidth BandwidthLimits
+
+	// RawOutput sends IQ samples without message headers and no status messages (rtl_tcp clients)
+	RawOutput bool
 }
 
 func CreateClientState(centerFrequency uint32) *ClientState {
 	var cs = &ClientState{
-		UUID:           uuid.New().String(),
-		Buffer:         make([]uint8, 64*1024),
-		CurrentState:   protocol.ParserAcquiringHeader,
-		ConnectedSince: time.Now(),
-		ReceivedBytes:  0,
-		SentBytes:      0,
-		Running:        false,
-		SentPackets:    0,
-		CmdReceived:    0,
-		ParserPosition: 0,
-		LogInstance:    SLog.Scope("ClientState"),
-		HeaderBuffer:   make([]uint8, protocol.MessageHeaderSize),
+		UUID:            uuid.New().String(),
+		Buffer:          make([]uint8, 64*1024),
+		CurrentState:    protocol.ParserAcquiringHeader,
+		ConnectedSince:  time.Now(),
+		LastCommandTime: time.Now(),
+		ReceivedBytes:   0,
+		SentBytes:       0,
+		SentPackets:     0,
+		CmdReceived:     0,
+		ParserPosition:  0,
+		LogInstance:     SLog.Scope("ClientState"),
+		HeaderBuffer:    make([]uint8, protocol.CommandHeaderSize),
 		CGS: ChannelGeneratorState{
 			Streaming:          false,
 			StreamingMode:      protocol.StreamModeIQOnly,
@@ -126,8 +144,15 @@ func (state *ClientState) Error(str interface{}, v ...interface{}) *ClientState
 	return state
 }
 
+// ProtocolError logs an error caused by the client misbehaving and counts it for banning
+func (state *ClientState) ProtocolError(str interface{}, v ...interface{}) *ClientState {
+	state.ProtocolErrors++
+	state.LogInstance.Error(str, v...)
+	return state
+}
+
 func (state *ClientState) Fatal(str interface{}, v ...interface{}) {
-	state.LogInstance.Fatal(str, v)
+	state.LogInstance.Fatal(str, v...)
 }
 
 func (state *ClientState) FullStop() {
@@ -140,8 +165,17 @@ func (state *ClientState) SendData(buffer []uint8) bool {
 	state.Lock()
 	defer state.Unlock()
 
+	if state.Timeouts.Write > 0 {
+		_ = state.Conn.SetWriteDeadline(time.Now().Add(state.Timeouts.Write))
+	}
+
 	n, err := state.Conn.Write(buffer)
 	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			state.LogInstance.Warn("Disconnecting: write timeout (client is not reading)")
+			state.Disconnect("write timeout")
+			return false
+		}
 		errMsg := err.Error()
 		if !strings.Contains(errMsg, "closed") && !strings.Contains(errMsg, "broken pipe") {
 			state.LogInstance.Error("Error sending data: %s", err)
@@ -158,11 +192,60 @@ func (state *ClientState) SendData(buffer []uint8) bool {
 	return true
 }
 
+// GetCGS returns a copy of the channel settings
+func (state *ClientState) GetCGS() ChannelGeneratorState {
+	state.cgsMtx.RLock()
+	defer state.cgsMtx.RUnlock()
+
+	return state.CGS
+}
+
+// GetSyncInfo returns a copy of the last sync sent to the client
+func (state *ClientState) GetSyncInfo() protocol.ClientSync {
+	state.cgsMtx.RLock()
+	defer state.cgsMtx.RUnlock()
+
+	return state.SyncInfo
+}
+
+// IsRunning returns false once the client was disconnected
+func (state *ClientState) IsRunning() bool {
+	return atomic.LoadInt32(&state.running) == 1
+}
+
+func (state *ClientState) SetRunning(running bool) {
+	var value = int32(0)
+	if running {
+		value = 1
+	}
+	atomic.StoreInt32(&state.running, value)
+}
+
+// AddReceivedBytes counts bytes read from the client
+func (state *ClientState) AddReceivedBytes(n int) {
+	state.Lock()
+	state.ReceivedBytes += uint64(n)
+	state.Unlock()
+}
+
+// GetCounters returns the traffic counters, which are updated from other goroutines
+func (state *ClientState) GetCounters() (receivedBytes, sentBytes, sentPackets uint64) {
+	state.Lock()
+	defer state.Unlock()
+
+	return state.ReceivedBytes, state.SentBytes, state.SentPackets
+}
+
+func (state *ClientState) sequenceNumber() uint32 {
+	_, _, sentPackets := state.GetCounters()
+	return uint32(sentPackets & 0xFFFFFFFF)
+}
+
 func (state *ClientState) onFFT(samples []float32) {
 	var samplesToSend interface{}
 	var msgType uint32
 
-	switch state.CGS.IQFormat {
+	switch state.GetCGS().IQFormat {
 	// TODO: DInt4
 	case protocol.StreamFormatUint8:
 		samplesToSend = tools.Float32ToUInt8(samples)
@@ -181,7 +264,7 @@ func (state *ClientState) onIQ(samples []complex64) {
 	var samplesToSend interface{}
 	var msgType uint32
 
-	switch state.CGS.IQFormat {
+	switch state.GetCGS().IQFormat {
 	case protocol.StreamFormatInt16:
 		samplesToSend = tools.Complex64ToInt16(samples)
 		msgType = protocol.MsgTypeInt16IQ
@@ -195,9 +278,16 @@ func (state *ClientState) onIQ(samples []complex64) {
 		samplesToSend = nil
 	}
 
-	if samplesToSend != nil {
-		state.SendIQ(samplesToSend, msgType)
+	if samplesToSend == nil {
+		return
 	}
+
+	if state.RawOutput {
+		state.SendData(tools.ArrayToBytes(samplesToSend))
+		return
+	}
+
+	state.SendIQ(samplesToSend, msgType)
 }
 
 func (state *ClientState) SendIQ(samples interface{}, messageType uint32) {
@@ -206,8 +296,8 @@ func (state *ClientState) SendIQ(samples interface{}, messageType uint32) {
 	var header = protocol.MessageHeader{
 		ProtocolID:     state.ServerVersion.ToUint32(),
 		MessageType:    messageType,
-		StreamType:     state.CGS.StreamingMode,
-		SequenceNumber: uint32(state.SentPackets & 0xFFFFFFFF),
+		StreamType:     state.GetCGS().StreamingMode,
+		SequenceNumber: state.sequenceNumber(),
 		BodySize:       uint32(len(bodyData)),
 	}
 
@@ -218,7 +308,7 @@ func (state *ClientState) SendIQ(samples interface{}, messageType uint32) {
 			segment := bodyData[:chunkSize]
 			bodyData = bodyData[chunkSize:]
 			header.BodySize = uint32(len(segment))
-			header.SequenceNumber = uint32(state.SentPackets & 0xFFFFFFFF)
+			header.SequenceNumber = state.sequenceNumber()
 			state.SendData(CreateRawPacket(header, segment))
 		}
 		return
@@ -228,29 +318,161 @@ func (state *ClientState) SendIQ(samples interface{}, messageType uint32) {
 }
 
 func (state *ClientState) updateSync() {
+	state.cgsMtx.Lock()
+	defer state.cgsMtx.Unlock()
+
 	state.SyncInfo.FFTCenterFrequency = state.CGS.FFTCenterFrequency
 	state.SyncInfo.IQCenterFrequency = state.CGS.IQCenterFrequency
-	state.SyncInfo.CanControl = state.ServerState.CanControl
-	state.SyncInfo.Gain = uint32(state.ServerState.Frontend.GetGain())
-	state.SyncInfo.DeviceCenterFrequency = state.ServerState.Frontend.GetCenterFrequency()
+	state.SyncInfo.CanControl = 0
+	if state.ServerState.HasControl(state) {
+		state.SyncInfo.CanControl = 1
+	}
+	state.SyncInfo.Gain = uint32(state.ServerState.DeviceGain())
+	state.SyncInfo.DeviceCenterFrequency = state.ServerState.DeviceCenterFrequency()
+
+	state.SyncInfo.MinimumIQCenterFrequency, state.SyncInfo.MaximumIQCenterFrequency = state.tunableWindow(state.CGS.IQDecimation)
+	state.SyncInfo.MinimumFFTCenterFrequency, state.SyncInfo.MaximumFFTCenterFrequency = state.tunableWindow(state.CGS.FFTDecimation)
+}
+
+// tunableWindow returns the range of center frequencies where a channel with the specified decimation stage
+// still fits inside the band captured by the frontend
+func (state *ClientState) tunableWindow(decimation uint32) (uint32, uint32) {
+	var deviceFrequency = state.ServerState.DeviceCenterFrequency()
+	var sampleRate = state.ServerState.DeviceSampleRate()
+	var channelSampleRate = sampleRate / tools.StageToNumber(decimation)
+	var halfSpan = (sampleRate - channelSampleRate) / 2
+
+	var minimumFrequency = uint32(0)
+	if deviceFrequency > halfSpan {
+		minimumFrequency = deviceFrequency - halfSpan
+	}
+
+	return minimumFrequency, deviceFrequency + halfSpan
+}
+
+func clampFrequency(frequency, minimumFrequency, maximumFrequency uint32) uint32 {
+	if frequency < minimumFrequency {
+		return minimumFrequency
+	}
+
+	if frequency > maximumFrequency {
+		return maximumFrequency
+	}
+
+	return frequency
+}
+
+// clampChannels moves the IQ and FFT channels back inside their tunable windows
+func (state *ClientState) clampChannels() {
+	state.cgsMtx.Lock()
+	defer state.cgsMtx.Unlock()
+
+	var minIQ, maxIQ = state.tunableWindow(state.CGS.IQDecimation)
+	var minFFT, maxFFT = state.tunableWindow(state.CGS.FFTDecimation)
+
+	state.CGS.IQCenterFrequency = clampFrequency(state.CGS.IQCenterFrequency, minIQ, maxIQ)
+	state.CGS.FFTCenterFrequency = clampFrequency(state.CGS.FFTCenterFrequency, minFFT, maxFFT)
+}
+
+func (state *ClientState) onDeviceFrequencyChanged() {
+	state.clampChannels()
+
+	if state.GetCGS().Streaming {
+		// Translator offsets are relative to the device frequency
+		state.CG.UpdateSettings(state)
+	}
+}
+
+// onDeviceSampleRateChanged keeps the decimations inside the new stage count and bandwidth limits and sends the new
+// DeviceInfo. The sync is sent by the caller, after every client was updated.
+func (state *ClientState) onDeviceSampleRateChanged() {
+	var stageCount = state.ServerState.GetDeviceInfo().DecimationStageCount
+	// The bandwidth limits read the other clients settings, so they are computed before taking cgsMtx
+	var minimumDecimation, _ = state.minimumIQDecimation(state.GetCGS().IQFormat)
+
+	state.cgsMtx.Lock()
+	if state.CGS.IQDecimation > stageCount {
+		state.CGS.IQDecimation = stageCount
+	}
+	if state.CGS.FFTDecimation > stageCount {
+		state.CGS.FFTDecimation = stageCount
+	}
+
+	// A higher sample rate might not fit the bandwidth limits anymore. The device change can't be rejected here.
+	var decimation = state.CGS.IQDecimation
+	if decimation < minimumDecimation {
+		state.CGS.IQDecimation = minimumDecimation
+	}
+	state.cgsMtx.Unlock()
+
+	if decimation < minimumDecimation {
+		state.Warn("IQ decimation %d exceeds bandwidth limits after the sample rate change. Using %d", decimation, minimumDecimation)
+	}
+
+	state.clampChannels()
+
+	if state.GetCGS().Streaming {
+		// Translator taps depend on the device sample rate
+		state.CG.UpdateSettings(state)
+	}
+
+	state.SendDeviceInfo()
+}
+
+// onFrontendRecovered rebuilds the channels for the re-opened frontend and lets the client know it is working again
+func (state *ClientState) onFrontendRecovered() {
+	state.clampChannels()
 
-	var halfSampleRate = state.ServerState.Frontend.GetSampleRate() / 2
-	var centerFreq = state.CGS.IQCenterFrequency
+	if state.GetCGS().Streaming {
+		state.CG.UpdateSettings(state)
+	}
+
+	state.SendDeviceInfo()
+	state.SendSync()
+	state.SendServerMessage(protocol.ServerMessageFrontendRecovered, "Frontend recovered")
+}
+
+func (state *ClientState) SendDeviceInfo() {
+	if state.RawOutput {
+		return
+	}
 
-	state.SyncInfo.MaximumIQCenterFrequency = centerFreq + halfSampleRate
-	state.SyncInfo.MinimumIQCenterFrequency = centerFreq - halfSampleRate
-	state.SyncInfo.MaximumFFTCenterFrequency = centerFreq + halfSampleRate
-	state.SyncInfo.MinimumFFTCenterFrequency = centerFreq - halfSampleRate
+	data := CreateDeviceInfo(state)
+	if !state.SendData(data) {
+		state.Error("Error sending deviceInfo packet")
+	}
 }
 
 func (state *ClientState) SendSync() {
 	state.updateSync()
+	if state.RawOutput {
+		return
+	}
+
 	data := CreateClientSync(state)
 	if !state.SendData(data) {
 		state.Error("Error sending syncInfo packet")
 	}
 }
 
+func (state *ClientState) SendServerMessage(code uint32, message string) {
+	if state.RawOutput {
+		return
+	}
+
+	data := CreateServerMessage(state.ServerVersion, state.sequenceNumber(), code, message)
+	if !state.SendData(data) {
+		state.Error("Error sending server message packet")
+	}
+}
+
+func (state *ClientState) SendReadSetting(setting, value uint32, text string) {
+	data := CreateReadSetting(state, setting, value, text)
+	if !state.SendData(data) {
+		state.Error("Error sending read setting packet")
+	}
+}
+
 func (state *ClientState) SendPong() {
 	data := CreatePong(state)
 	if !state.SendData(data) {
@@ -259,6 +481,11 @@ func (state *ClientState) SendPong() {
 }
 
 func (state *ClientState) SetSetting(setting uint32, args []uint32) bool {
+	if len(args) == 0 {
+		state.Error("Setting %d without arguments", setting)
+		return false
+	}
+
 	switch setting {
 	case protocol.SettingStreamingMode:
 		return state.SetStreamingMode(args[0])
@@ -282,13 +509,66 @@ func (state *ClientState) SetSetting(setting uint32, args []uint32) bool {
 		return state.SetFFTDBOffset(int32(args[0]))
 	case protocol.SettingFFTDisplayPixels:
 		return state.SetFFTDisplayPixels(args[0])
+	case protocol.SettingDeviceFrequency:
+		return state.SetDeviceFrequency(args[0])
+	case protocol.SettingDeviceChannel:
+		return state.SetDeviceChannel(args[0])
+	case protocol.SettingDeviceAntenna:
+		return state.SetDeviceAntenna(args[0])
+	case protocol.SettingDeviceAGC:
+		return state.SetDeviceAGC(args[0] == 1)
+	case protocol.SettingDeviceBiasT:
+		return state.SetDeviceBiasT(args[0] == 1)
+	case protocol.SettingDeviceSampleRate:
+		return state.SetDeviceSampleRate(args[0])
 	}
 
 	return false
 }
 
+// SettingValue returns the current value of a setting for this client, false for settings it doesn't know
+func (state *ClientState) SettingValue(setting uint32) (uint32, bool) {
+	var cgs = state.GetCGS()
+
+	switch setting {
+	case protocol.SettingStreamingMode:
+		return cgs.StreamingMode, true
+	case protocol.SettingStreamingEnabled:
+		return boolToUint32(cgs.Streaming), true
+	case protocol.SettingGain:
+		return uint32(state.ServerState.DeviceGain()), true
+	case protocol.SettingIqFormat:
+		return cgs.IQFormat, true
+	case protocol.SettingIqFrequency:
+		return cgs.IQCenterFrequency, true
+	case protocol.SettingIqDecimation:
+		return cgs.IQDecimation, true
+	case protocol.SettingFFTFormat:
+		return cgs.FFTFormat, true
+	case protocol.SettingFFTFrequency:
+		return cgs.FFTCenterFrequency, true
+	case protocol.SettingFFTDecimation:
+		return cgs.FFTDecimation, true
+	case protocol.SettingFFTDbOffset:
+		return uint32(cgs.FFTDBOffset), true
+	case protocol.SettingFFTDbRange:
+		return cgs.FFTDBRange, true
+	case protocol.SettingFFTDisplayPixels:
+		return cgs.FFTDisplayPixels, true
+	case protocol.SettingDeviceFrequency:
+		return state.ServerState.DeviceCenterFrequency(), true
+	case protocol.SettingDeviceChannel, protocol.SettingDeviceAntenna, protocol.SettingDeviceAGC, protocol.SettingDeviceBiasT, protocol.SettingDeviceSampleRate:
+		value, _ := state.ServerState.ReadDeviceSetting(setting)
+		return value, true
+	}
+
+	return 0, false
+}
+
 func (state *ClientState) SetStreamingMode(mode uint32) bool {
+	state.cgsMtx.Lock()
 	state.CGS.StreamingMode = mode
+	state.cgsMtx.Unlock()
 	return true
 }
 func (state *ClientState) SetStreamingEnabled(enabled bool) bool {
@@ -297,63 +577,155 @@ func (state *ClientState) SetStreamingEnabled(enabled bool) bool {
 		enabledString = "Disabled"
 	}
 
+	if enabled {
+		// Other clients might have started streaming since the settings were applied
+		var cgs = state.GetCGS()
+		decimation, ok := state.enforceBandwidth(cgs.IQDecimation, cgs.IQFormat)
+		if !ok {
+			return false
+		}
+		state.cgsMtx.Lock()
+		state.CGS.IQDecimation = decimation
+		state.cgsMtx.Unlock()
+		state.clampChannels()
+	}
+
 	state.Log("Streaming %s", enabledString)
+	state.cgsMtx.Lock()
 	state.CGS.Streaming = enabled
+	state.cgsMtx.Unlock()
 
 	return true
 }
 func (state *ClientState) SetIQFormat(format uint32) bool {
+	decimation, ok := state.enforceBandwidth(state.GetCGS().IQDecimation, format)
+	if !ok {
+		return false
+	}
+
+	state.cgsMtx.Lock()
 	state.CGS.IQFormat = format
+	state.CGS.IQDecimation = decimation
+	state.cgsMtx.Unlock()
+	state.clampChannels()
 	return true
 }
 func (state *ClientState) SetGain(gain uint32) bool {
-	state.ServerState.Frontend.SetGain(uint8(gain))
-	return true
+	return state.deviceSettingResult(protocol.SettingGain, state.ServerState.SetDeviceGain(uint8(gain)))
 }
 func (state *ClientState) SetIQFrequency(frequency uint32) bool {
-	state.CGS.IQCenterFrequency = frequency
+	state.cgsMtx.Lock()
+	var minimumFrequency, maximumFrequency = state.tunableWindow(state.CGS.IQDecimation)
+	var applied = clampFrequency(frequency, minimumFrequency, maximumFrequency)
+	state.CGS.IQCenterFrequency = applied
+	state.cgsMtx.Unlock()
+	if applied != frequency {
+		state.Warn("IQ Frequency %d is outside the tunable window (%d - %d). Using %d", frequency, minimumFrequency, maximumFrequency, applied)
+	}
 	state.updateSync()
 	return true
 }
 func (state *ClientState) SetIQDecimation(decimation uint32) bool {
-	if state.ServerState.DeviceInfo.DecimationStageCount >= decimation {
+	if state.ServerState.GetDeviceInfo().DecimationStageCount >= decimation {
+		decimation, ok := state.enforceBandwidth(decimation, state.GetCGS().IQFormat)
+		if !ok {
+			return false
+		}
+
+		state.cgsMtx.Lock()
 		state.CGS.IQDecimation = decimation
+		state.cgsMtx.Unlock()
+		state.clampChannels()
 		return true
 	}
 
 	return false
 }
 func (state *ClientState) SetFFTFormat(format uint32) bool {
+	state.cgsMtx.Lock()
 	state.CGS.FFTFormat = format
+	state.cgsMtx.Unlock()
 	return true
 }
 
 func (state *ClientState) SetFFTFrequency(frequency uint32) bool {
-	state.CGS.FFTCenterFrequency = frequency
+	state.cgsMtx.Lock()
+	var minimumFrequency, maximumFrequency = state.tunableWindow(state.CGS.FFTDecimation)
+	var applied = clampFrequency(frequency, minimumFrequency, maximumFrequency)
+	state.CGS.FFTCenterFrequency = applied
+	state.cgsMtx.Unlock()
+	if applied != frequency {
+		state.Warn("FFT Frequency %d is outside the tunable window (%d - %d). Using %d", frequency, minimumFrequency, maximumFrequency, applied)
+	}
 	state.updateSync()
 	return true
 }
 
 func (state *ClientState) SetFFTDecimation(decimation uint32) bool {
-	if state.ServerState.DeviceInfo.DecimationStageCount >= decimation {
+	if state.ServerState.GetDeviceInfo().DecimationStageCount >= decimation {
+		state.cgsMtx.Lock()
 		state.CGS.FFTDecimation = decimation
+		state.cgsMtx.Unlock()
+		state.clampChannels()
+		return true
+	}
+
+	return false
+}
+
+// deviceSettingResult tells the client why a device setting was not applied. Returns true if err is nil.
+func (state *ClientState) deviceSettingResult(setting uint32, err error) bool {
+	if err == nil {
 		return true
 	}
 
+	state.Warn("Error setting %s: %s", protocol.SettingNames[setting], err)
+	state.SendServerMessage(protocol.ServerMessageDeviceError, fmt.Sprintf("Error setting %s: %s", protocol.SettingNames[setting], err))
+
 	return false
 }
 
+func (state *ClientState) SetDeviceFrequency(frequency uint32) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceFrequency, state.ServerState.SetDeviceFrequency(frequency))
+}
+
+func (state *ClientState) SetDeviceChannel(channel uint32) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceChannel, state.ServerState.SetDeviceChannel(channel))
+}
+
+func (state *ClientState) SetDeviceAntenna(antenna uint32) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceAntenna, state.ServerState.SetDeviceAntenna(antenna))
+}
+
+func (state *ClientState) SetDeviceAGC(agc bool) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceAGC, state.ServerState.SetDeviceAGC(agc))
+}
+
+func (state *ClientState) SetDeviceBiasT(biasT bool) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceBiasT, state.ServerState.SetDeviceBiasT(biasT))
+}
+
+func (state *ClientState) SetDeviceSampleRate(sampleRate uint32) bool {
+	return state.deviceSettingResult(protocol.SettingDeviceSampleRate, state.ServerState.SetDeviceSampleRate(sampleRate))
+}
+
 func (state *ClientState) SetFFTDBOffset(offset int32) bool {
+	state.cgsMtx.Lock()
 	state.CGS.FFTDBOffset = offset
+	state.cgsMtx.Unlock()
 	return true
 }
 func (state *ClientState) SetFFTDBRange(fftRange uint32) bool {
+	state.cgsMtx.Lock()
 	state.CGS.FFTDBRange = fftRange
+	state.cgsMtx.Unlock()
 	return false
 }
 func (state *ClientState) SetFFTDisplayPixels(pixels uint32) bool {
 	if pixels >= protocol.FFTMinDisplayPixels && pixels <= protocol.FFTMaxDisplayPixels {
+		state.cgsMtx.Lock()
 		state.CGS.FFTDisplayPixels = pixels
+		state.cgsMtx.Unlock()
 		return true
 	}
 	return false
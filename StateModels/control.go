@@ -0,0 +1,246 @@
+package StateModels
+
+import (
+	"github.com/racerxdl/radioserver/SLog"
+	"strings"
+	"time"
+)
+
+var controlLog = SLog.Scope("Control")
+
+// Control Modes
+const (
+	// ControlModeNone no client is allowed to control the frontend
+	ControlModeNone = iota
+	// ControlModeFirstCome the first connected client gets control. It is handed to the next oldest client when it leaves.
+	ControlModeFirstCome
+	// ControlModeAdmin only clients assigned through the admin API get control
+	ControlModeAdmin
+	// ControlModeLease clients get control for a limited time, then it is handed to the next client
+	ControlModeLease
+)
+
+var ControlModeNames = map[int]string{
+	ControlModeNone:      "none",
+	ControlModeFirstCome: "firstcome",
+	ControlModeAdmin:     "admin",
+	ControlModeLease:     "lease",
+}
+
+const defaultControlLease = 5 * time.Minute
+
+func ParseControlMode(name string) (int, bool) {
+	name = strings.ToLower(name)
+	for k, v := range ControlModeNames {
+		if v == name {
+			return k, true
+		}
+	}
+
+	return ControlModeNone, false
+}
+
+// SetControlMode changes the control arbitration mode. The current owner (if any) loses the control token, and in
+// firstcome and lease modes it's given to the oldest client. Connected clients are told about the new owner.
+func (s *ServerState) SetControlMode(mode int, lease time.Duration) {
+	if lease <= 0 {
+		lease = defaultControlLease
+	}
+
+	s.controlMtx.Lock()
+	var previousOwner = s.controlOwner
+	s.controlMode = mode
+	s.controlLease = lease
+	s.controlOwner = nil
+	var startLeaseRoutine = mode == ControlModeLease && !s.leaseRoutineRunning
+	if startLeaseRoutine {
+		s.leaseRoutineRunning = true
+	}
+	s.controlMtx.Unlock()
+
+	s.logger(controlLog).Info("Control mode set to %s", ControlModeNames[mode])
+
+	var owner *ClientState
+	if mode == ControlModeFirstCome || mode == ControlModeLease {
+		// Connected clients don't go through onClientAdded again
+		var clients = s.GetClients()
+		if len(clients) > 0 {
+			owner = clients[0]
+			s.setControlOwner(owner)
+		}
+	}
+
+	if owner != previousOwner {
+		s.SendSync()
+	}
+
+	if startLeaseRoutine {
+		go s.leaseRoutine()
+	}
+}
+
+func (s *ServerState) GetControlMode() int {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	return s.controlMode
+}
+
+// HasControl returns true if the specified client currently holds the control token. Nobody has control while the
+// frontend is being recovered.
+func (s *ServerState) HasControl(state *ClientState) bool {
+	if s.IsFrontendRecovering() {
+		return false
+	}
+
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	return s.controlOwner != nil && s.controlOwner.UUID == state.UUID
+}
+
+// ControlOwner returns the client that holds the control token or nil if nobody does
+func (s *ServerState) ControlOwner() *ClientState {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	return s.controlOwner
+}
+
+// ControlLeaseExpiration returns when the current lease expires. Only meaningful in ControlModeLease.
+func (s *ServerState) ControlLeaseExpiration() time.Time {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	return s.controlExpiration
+}
+
+// AssignControl gives the control token to the specified client and announces the hand-off to every client
+func (s *ServerState) AssignControl(state *ClientState) bool {
+	if s.GetControlMode() == ControlModeNone {
+		s.logger(controlLog).Warn("Cannot assign control to %s: control mode is none", state.UUID)
+		return false
+	}
+
+	if s.setControlOwner(state) {
+		s.SendSync()
+	}
+
+	return true
+}
+
+// RevokeControl removes the control token from the current owner and announces it to every client
+func (s *ServerState) RevokeControl() {
+	if s.setControlOwner(nil) {
+		s.SendSync()
+	}
+}
+
+func (s *ServerState) setControlOwner(state *ClientState) bool {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+
+	return s.setControlOwnerLocked(state)
+}
+
+// setControlOwnerIfNone gives the control token to the specified client only if nobody holds it, checking and setting
+// it under the same lock so two new clients can't both get it
+func (s *ServerState) setControlOwnerIfNone(state *ClientState) bool {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+
+	if s.controlOwner != nil {
+		return false
+	}
+
+	return s.setControlOwnerLocked(state)
+}
+
+// setControlOwnerLocked is setControlOwner for callers holding controlMtx
+func (s *ServerState) setControlOwnerLocked(state *ClientState) bool {
+	var changed = s.controlOwner != state
+	s.controlOwner = state
+	s.controlExpiration = time.Now().Add(s.controlLease)
+
+	if changed {
+		if state != nil {
+			s.logger(controlLog).Info("Control given to %s (%s)", state.UUID, state.Addr)
+		} else {
+			s.logger(controlLog).Info("Control revoked")
+		}
+	}
+
+	return changed
+}
+
+// onClientAdded should be called without holding clientListMtx
+func (s *ServerState) onClientAdded(state *ClientState) {
+	var mode = s.GetControlMode()
+	if mode != ControlModeFirstCome && mode != ControlModeLease {
+		return
+	}
+
+	// The new client will get the CanControl flag in the sync after hello, other clients did not have control
+	// before so there is nothing to announce.
+	s.setControlOwnerIfNone(state)
+}
+
+// onClientRemoved should be called without holding clientListMtx
+func (s *ServerState) onClientRemoved(state *ClientState) {
+	var owner = s.ControlOwner()
+	if owner == nil || owner.UUID != state.UUID {
+		return
+	}
+
+	var next *ClientState
+	var mode = s.GetControlMode()
+	if mode == ControlModeFirstCome || mode == ControlModeLease {
+		var clients = s.GetClients()
+		if len(clients) > 0 {
+			next = clients[0]
+		}
+	}
+
+	if s.setControlOwner(next) {
+		s.SendSync()
+	}
+}
+
+func (s *ServerState) leaseRoutine() {
+	for {
+		time.Sleep(time.Second)
+
+		s.controlMtx.Lock()
+		var running = s.controlMode == ControlModeLease
+		var owner = s.controlOwner
+		var expired = time.Now().After(s.controlExpiration)
+		if !running {
+			s.leaseRoutineRunning = false
+		}
+		s.controlMtx.Unlock()
+
+		if !running {
+			return
+		}
+
+		if owner != nil && !expired {
+			continue
+		}
+
+		var clients = s.GetClients()
+		if len(clients) == 0 {
+			continue
+		}
+
+		// Hand the token to the client after the current owner, wrapping around
+		var next = clients[0]
+		if owner != nil {
+			for i, v := range clients {
+				if v.UUID == owner.UUID {
+					next = clients[(i+1)%len(clients)]
+					break
+				}
+			}
+		}
+
+		if s.setControlOwner(next) {
+			s.SendSync()
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package StateModels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/protocol"
+	"time"
+)
+
+// defaultRetryInterval is the time between attempts to open a frontend that is not available
+const defaultRetryInterval = 5 * time.Second
+
+var frontendLog = SLog.Scope("Frontend")
+
+var errFrontendNotOpen = errors.New("frontend is not open")
+var errFrontendStalled = errors.New("frontend stopped sending samples")
+
+// FrontendSetup applies the configured settings after the frontend is opened for the first time
+type FrontendSetup func(frontend frontends.Frontend) error
+
+// OpenFrontend opens the frontend and applies setup. If that fails the server keeps running without it: new clients
+// are rejected with the error (see FrontendUnavailable) and it is tried again every retryInterval in background, until
+// it works or ctx is done. Returns true if the frontend was opened at the first attempt.
+func (s *ServerState) OpenFrontend(ctx context.Context, setup FrontendSetup, retryInterval time.Duration) bool {
+	if retryInterval > 0 {
+		s.retryInterval = retryInterval
+	}
+
+	var open = func() error {
+		s.deviceMtx.Lock()
+		defer s.deviceMtx.Unlock()
+
+		err := s.Frontend.Init(ctx)
+		if err == nil && setup != nil {
+			err = setup(s.Frontend)
+		}
+
+		if err != nil {
+			_ = s.Frontend.Destroy()
+			return err
+		}
+
+		s.updateDeviceInfo()
+		return nil
+	}
+
+	if s.tryOpen(open, 1) {
+		return true
+	}
+
+	go func() {
+		if s.retryOpen(ctx, open, 2) {
+			s.logger(frontendLog).Info("%s is available", s.Frontend.GetName())
+		}
+	}()
+
+	return false
+}
+
+// tryOpen calls open, keeping the error if it fails. Clients (if any) are told about new errors.
+func (s *ServerState) tryOpen(open func() error, attempt int) bool {
+	err := open()
+	if err == nil {
+		s.setFrontendAvailable()
+		return true
+	}
+
+	s.logger(frontendLog).Error("Attempt %d to open %s failed: %s. Trying again in %s", attempt, s.Frontend.GetShortName(), err, s.retryInterval)
+	if s.setFrontendError(err, true) {
+		s.SendServerMessage(protocol.ServerMessageFrontendLost, fmt.Sprintf("Error opening the frontend: %s", err))
+	}
+
+	return false
+}
+
+// retryOpen waits retryInterval and calls open again until it works. Returns false if ctx was done before that.
+func (s *ServerState) retryOpen(ctx context.Context, open func() error, attempt int) bool {
+	for ; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(s.retryInterval):
+		}
+
+		if s.tryOpen(open, attempt) {
+			return true
+		}
+	}
+}
+
+func (s *ServerState) setFrontendAvailable() {
+	s.frontendMtx.Lock()
+	s.frontendAvailable = true
+	s.frontendMtx.Unlock()
+}
+
+// setFrontendError keeps err as the last frontend error. Errors that make the frontend unavailable reject new clients
+// until it is opened again. Returns true if err is different from the last error.
+func (s *ServerState) setFrontendError(err error, unavailable bool) bool {
+	s.frontendMtx.Lock()
+	defer s.frontendMtx.Unlock()
+
+	var changed = s.frontendErr == nil || s.frontendErr.Error() != err.Error()
+	s.frontendErr = err
+	s.frontendErrTime = time.Now()
+	if unavailable {
+		s.frontendAvailable = false
+	}
+
+	return changed
+}
+
+// FrontendUnavailable returns nil if the frontend is open and working, otherwise the reason it is not
+func (s *ServerState) FrontendUnavailable() error {
+	s.frontendMtx.Lock()
+	defer s.frontendMtx.Unlock()
+
+	if s.frontendAvailable {
+		return nil
+	}
+
+	if s.frontendErr == nil {
+		return errFrontendNotOpen
+	}
+
+	return s.frontendErr
+}
+
+// LastFrontendError returns the last error of the frontend and when it happened, nil if there was none
+func (s *ServerState) LastFrontendError() (time.Time, error) {
+	s.frontendMtx.Lock()
+	defer s.frontendMtx.Unlock()
+
+	return s.frontendErrTime, s.frontendErr
+}
+
+// frontendFailed logs and keeps an error of an operation that does not make the frontend unavailable
+func (s *ServerState) frontendFailed(operation string, err error) error {
+	if err != nil {
+		s.logger(frontendLog).Error("Error %s %s: %s", operation, s.Frontend.GetShortName(), err)
+		s.setFrontendError(fmt.Errorf("error %s: %s", operation, err), false)
+	}
+
+	return err
+}
@@ -7,14 +7,20 @@ import (
 )
 
 func CreateDeviceInfo(state *ClientState) []uint8 {
-	var deviceInfo = state.ServerState.DeviceInfo
+	var deviceInfo = state.ServerState.GetDeviceInfo()
+	var format = deviceInfo.ForcedIQFormat
+	if format == protocol.StreamFormatInvalid {
+		format = protocol.StreamFormatUint8
+	}
+	deviceInfo.MinimumIQDecimation, _ = state.minimumIQDecimation(format)
+
 	var bodyData = tools.StructToBytes(deviceInfo)
 
 	var header = protocol.MessageHeader{
 		ProtocolID:     state.ServerVersion.ToUint32(),
 		MessageType:    protocol.MsgTypeDeviceInfo,
 		StreamType:     protocol.StreamTypeStatus,
-		SequenceNumber: uint32(state.SentPackets & 0xFFFFFFFF),
+		SequenceNumber: state.sequenceNumber(),
 		BodySize:       uint32(len(bodyData)),
 	}
 
@@ -22,14 +28,14 @@ func CreateDeviceInfo(state *ClientState) []uint8 {
 }
 
 func CreateClientSync(state *ClientState) []uint8 {
-	var syncInfo = state.SyncInfo
+	var syncInfo = state.GetSyncInfo()
 	var bodyData = tools.StructToBytes(syncInfo)
 
 	var header = protocol.MessageHeader{
 		ProtocolID:     state.ServerVersion.ToUint32(),
 		MessageType:    protocol.MsgTypeClientSync,
 		StreamType:     protocol.StreamTypeStatus,
-		SequenceNumber: uint32(state.SentPackets & 0xFFFFFFFF),
+		SequenceNumber: state.sequenceNumber(),
 		BodySize:       uint32(len(bodyData)),
 	}
 
@@ -47,7 +53,36 @@ func CreatePong(state *ClientState) []uint8 {
 		ProtocolID:     state.ServerVersion.ToUint32(),
 		MessageType:    protocol.MsgTypePong,
 		StreamType:     protocol.StreamTypeStatus,
-		SequenceNumber: uint32(state.SentPackets & 0xFFFFFFFF),
+		SequenceNumber: state.sequenceNumber(),
+		BodySize:       uint32(len(bodyData)),
+	}
+
+	return append(tools.StructToBytes(header), bodyData...)
+}
+
+// CreateServerMessage does not need a ClientState so it can be sent to connections that were rejected
+func CreateServerMessage(serverVersion protocol.Version, sequenceNumber uint32, code uint32, message string) []uint8 {
+	var bodyData = append(tools.StructToBytes(protocol.ServerMessage{Code: code}), []uint8(message)...)
+
+	var header = protocol.MessageHeader{
+		ProtocolID:     serverVersion.ToUint32(),
+		MessageType:    protocol.MsgTypeServerMessage,
+		StreamType:     protocol.StreamTypeStatus,
+		SequenceNumber: sequenceNumber,
+		BodySize:       uint32(len(bodyData)),
+	}
+
+	return append(tools.StructToBytes(header), bodyData...)
+}
+
+func CreateReadSetting(state *ClientState, setting, value uint32, text string) []uint8 {
+	var bodyData = append(tools.StructToBytes(protocol.ReadSetting{Setting: setting, Value: value}), []uint8(text)...)
+
+	var header = protocol.MessageHeader{
+		ProtocolID:     state.ServerVersion.ToUint32(),
+		MessageType:    protocol.MsgTypeReadSetting,
+		StreamType:     protocol.StreamTypeStatus,
+		SequenceNumber: state.sequenceNumber(),
 		BodySize:       uint32(len(bodyData)),
 	}
 
@@ -60,8 +95,8 @@ func CreateDataPacket(state *ClientState, messageType uint32, samples interface{
 	var header = protocol.MessageHeader{
 		ProtocolID:     state.ServerVersion.ToUint32(),
 		MessageType:    messageType,
-		StreamType:     state.CGS.StreamingMode,
-		SequenceNumber: uint32(state.SentPackets & 0xFFFFFFFF),
+		StreamType:     state.GetCGS().StreamingMode,
+		SequenceNumber: state.sequenceNumber(),
 		BodySize:       uint32(len(bodyData)),
 	}
 
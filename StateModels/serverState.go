@@ -1,24 +1,91 @@
 package StateModels
 
 import (
+	"fmt"
 	"github.com/racerxdl/radioserver/SLog"
 	"github.com/racerxdl/radioserver/frontends"
 	"github.com/racerxdl/radioserver/protocol"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+const maxRecentDisconnects = 100
+
+var serverLog = SLog.Scope("RadioServer")
+
+// DisconnectInfo is kept for a while after a client disconnects, to be able to tell why it happened
+type DisconnectInfo struct {
+	UUID           string
+	Name           string
+	Address        string
+	ConnectedSince time.Time
+	DisconnectedAt time.Time
+	Reason         string
+	ReceivedBytes  uint64
+	SentBytes      uint64
+}
+
+// ServerState is a frontend with its own clients, control owner and start / stop lifecycle. A server can run more
+// than one, each listener serves a single one.
 type ServerState struct {
-	DeviceInfo    protocol.DeviceInfo
+	// Name identifies the frontend in the configuration and in the admin API
+	Name          string
 	clients       []*ClientState
 	clientListMtx sync.Mutex
 	Frontend      frontends.Frontend
-	CanControl    uint32
+
+	// deviceMtx is held to change the frontend (settings, Start / Stop, re-opening it) and the DeviceInfo, and to read
+	// them from the client goroutines, so they never see them half way through. It's taken after clientListMtx, never
+	// before.
+	deviceMtx  sync.RWMutex
+	deviceInfo protocol.DeviceInfo
+
+	// AGC and Bias-T can't be read from the frontends, so the last values set are kept here
+	deviceAGC   bool
+	deviceBiasT bool
+
+	recentDisconnects []DisconnectInfo
+
+	// Frontend Availability
+	frontendMtx       sync.Mutex
+	frontendAvailable bool
+	frontendErr       error
+	frontendErrTime   time.Time
+	retryInterval     time.Duration
+
+	// Frontend Watchdog
+	watchdogTimeout time.Duration
+	lastSamples     int64
+	recovering      int32
+
+	// Control Arbitration
+	controlMtx          sync.Mutex
+	controlMode         int
+	controlOwner        *ClientState
+	controlLease        time.Duration
+	controlExpiration   time.Time
+	leaseRoutineRunning bool
+}
+
+// logger adds the frontend name to the messages of scope when the server has more than one frontend
+func (s *ServerState) logger(scope *SLog.Instance) *SLog.Instance {
+	if s.Name == "" {
+		return scope
+	}
+
+	return scope.With("frontend", s.Name)
 }
 
 func CreateServerState() *ServerState {
 	return &ServerState{
 		clientListMtx: sync.Mutex{},
 		clients:       make([]*ClientState, 0),
+		controlMtx:    sync.Mutex{},
+		controlMode:   ControlModeNone,
+		controlLease:  defaultControlLease,
+		retryInterval: defaultRetryInterval,
 	}
 }
 
@@ -32,52 +99,413 @@ func (s *ServerState) indexOfClient(state *ClientState) int {
 	return -1
 }
 
-func (s *ServerState) PushClient(state *ClientState) {
+// GetClients returns a copy of the connected client list
+func (s *ServerState) GetClients() []*ClientState {
 	s.clientListMtx.Lock()
 	defer s.clientListMtx.Unlock()
 
+	var clientList = make([]*ClientState, len(s.clients))
+	copy(clientList, s.clients)
+
+	return clientList
+}
+
+// GetRecentDisconnects returns a copy of the last disconnected clients, oldest first
+func (s *ServerState) GetRecentDisconnects() []DisconnectInfo {
+	s.clientListMtx.Lock()
+	defer s.clientListMtx.Unlock()
+
+	var list = make([]DisconnectInfo, len(s.recentDisconnects))
+	copy(list, s.recentDisconnects)
+
+	return list
+}
+
+func (s *ServerState) FindClient(uuid string) *ClientState {
+	s.clientListMtx.Lock()
+	defer s.clientListMtx.Unlock()
+
+	for _, v := range s.clients {
+		if v.UUID == uuid {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (s *ServerState) PushClient(state *ClientState) {
+	s.clientListMtx.Lock()
+
 	count := len(s.clients)
 
 	s.clients = append(s.clients, state)
-	if count == 0 {
-		SLog.Info("First client connected. Starting frontend...")
-		s.Frontend.Start()
+	if count == 0 && !s.IsFrontendRecovering() {
+		s.logger(serverLog).Info("First client connected. Starting frontend...")
+		s.markSamplesReceived()
+		_ = s.frontendFailed("starting", s.startFrontend())
 	}
+	s.clientListMtx.Unlock()
+
+	s.onClientAdded(state)
 }
 
 func (s *ServerState) RemoveClient(state *ClientState) {
 	s.clientListMtx.Lock()
-	defer s.clientListMtx.Unlock()
 	idx := s.indexOfClient(state)
 	if idx != -1 {
 		s.clients = append(s.clients[:idx], s.clients[idx+1:]...)
 	}
 
-	if len(s.clients) == 0 {
-		SLog.Info("Last client gone. Stopping frontend...")
-		s.Frontend.Stop()
+	if len(s.clients) == 0 && !s.IsFrontendRecovering() {
+		s.logger(serverLog).Info("Last client gone. Stopping frontend...")
+		_ = s.frontendFailed("stopping", s.stopFrontend())
+	}
+
+	receivedBytes, sentBytes, _ := state.GetCounters()
+	s.recentDisconnects = append(s.recentDisconnects, DisconnectInfo{
+		UUID:           state.UUID,
+		Name:           state.Name,
+		Address:        state.Addr.String(),
+		ConnectedSince: state.ConnectedSince,
+		DisconnectedAt: time.Now(),
+		Reason:         state.GetDisconnectReason(),
+		ReceivedBytes:  receivedBytes,
+		SentBytes:      sentBytes,
+	})
+	if len(s.recentDisconnects) > maxRecentDisconnects {
+		s.recentDisconnects = s.recentDisconnects[len(s.recentDisconnects)-maxRecentDisconnects:]
 	}
+	s.clientListMtx.Unlock()
+
+	s.onClientRemoved(state)
 }
 
 func (s *ServerState) SendSync() bool {
-	s.clientListMtx.Lock()
-	defer s.clientListMtx.Unlock()
-
-	for i := 0; i < len(s.clients); i++ {
-		s.clients[i].SendSync()
+	for _, v := range s.GetClients() {
+		v.SendSync()
 	}
 
 	return true
 }
 
 func (s *ServerState) PushSamples(samples []complex64) {
-	var clientList []*ClientState
-	s.clientListMtx.Lock()
-	clientList = make([]*ClientState, len(s.clients))
-	copy(clientList, s.clients)
-	s.clientListMtx.Unlock()
-
-	for _, v := range clientList {
+	s.markSamplesReceived()
+	for _, v := range s.GetClients() {
 		v.CG.PushSamples(samples)
 	}
 }
+
+// SetDeviceFrequency retunes the frontend and moves every client channel into the new tunable window
+func (s *ServerState) SetDeviceFrequency(frequency uint32) error {
+	s.deviceMtx.Lock()
+	if frequency < s.deviceInfo.MinimumFrequency || frequency > s.deviceInfo.MaximumFrequency {
+		s.deviceMtx.Unlock()
+		return fmt.Errorf("frequency %d is outside the device range (%d - %d)", frequency, s.deviceInfo.MinimumFrequency, s.deviceInfo.MaximumFrequency)
+	}
+
+	appliedFrequency, err := s.Frontend.SetCenterFrequency(frequency)
+	s.deviceMtx.Unlock()
+	if s.frontendFailed("setting the frequency", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device frequency set to %d", appliedFrequency)
+
+	for _, v := range s.GetClients() {
+		v.onDeviceFrequencyChanged()
+	}
+
+	return nil
+}
+
+// SetDeviceChannel switches the frontend to another RX channel, for frontends that have more than one
+func (s *ServerState) SetDeviceChannel(channel uint32) error {
+	selector, ok := s.Frontend.(frontends.ChannelSelector)
+	if !ok {
+		return frontends.ErrNotSupported
+	}
+
+	s.deviceMtx.Lock()
+	err := selector.SetChannel(channel)
+	// Switching channels re-applies the frequency and can reset the antenna, even if it failed half way
+	s.updateDeviceInfo()
+	s.deviceMtx.Unlock()
+
+	for _, v := range s.GetClients() {
+		v.onDeviceFrequencyChanged()
+	}
+	s.SendSync()
+
+	if s.frontendFailed("setting the channel", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device channel set to %d", channel)
+
+	return nil
+}
+
+// SetDeviceGain sets the frontend gain index
+func (s *ServerState) SetDeviceGain(gain uint8) error {
+	s.deviceMtx.Lock()
+	err := s.Frontend.SetGain(gain)
+	s.deviceMtx.Unlock()
+
+	return s.frontendFailed("setting the gain", err)
+}
+
+// GetDeviceAntennas returns the antennas of the frontend and the index of the selected one. Frontends with a single
+// antenna return an empty list.
+func (s *ServerState) GetDeviceAntennas() ([]string, uint32) {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.deviceAntennas()
+}
+
+// deviceAntennas is GetDeviceAntennas for callers holding deviceMtx
+func (s *ServerState) deviceAntennas() ([]string, uint32) {
+	selector, ok := s.Frontend.(frontends.AntennaSelector)
+	if !ok {
+		return []string{}, 0
+	}
+
+	var antennas = selector.GetAntennas()
+	var selected = selector.GetAntenna()
+	for i, v := range antennas {
+		if v == selected {
+			return antennas, uint32(i)
+		}
+	}
+
+	return antennas, 0
+}
+
+// GetDeviceChannels returns the RX channels of the frontend and the selected one. Frontends with a single channel
+// return an empty list.
+func (s *ServerState) GetDeviceChannels() ([]string, uint32) {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.deviceChannels()
+}
+
+// deviceChannels is GetDeviceChannels for callers holding deviceMtx
+func (s *ServerState) deviceChannels() ([]string, uint32) {
+	selector, ok := s.Frontend.(frontends.ChannelSelector)
+	if !ok || selector.GetChannelCount() <= 1 {
+		return []string{}, 0
+	}
+
+	var channels = make([]string, selector.GetChannelCount())
+	for i := range channels {
+		channels[i] = strconv.Itoa(i)
+	}
+
+	return channels, selector.GetChannel()
+}
+
+// SetDeviceAntenna selects the frontend antenna by its index in GetDeviceAntennas
+func (s *ServerState) SetDeviceAntenna(antenna uint32) error {
+	s.deviceMtx.Lock()
+	var antennas, _ = s.deviceAntennas()
+	if antenna >= uint32(len(antennas)) {
+		s.deviceMtx.Unlock()
+		return fmt.Errorf("frontend %s has no antenna %d", s.Frontend.GetShortName(), antenna)
+	}
+
+	err := s.Frontend.SetAntenna(antennas[antenna])
+	s.deviceMtx.Unlock()
+	if s.frontendFailed("setting the antenna", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device antenna set to %s", antennas[antenna])
+
+	return nil
+}
+
+func (s *ServerState) SetDeviceAGC(agc bool) error {
+	s.deviceMtx.Lock()
+	err := s.Frontend.SetAGC(agc)
+	if err == nil {
+		s.deviceAGC = agc
+	}
+	s.deviceMtx.Unlock()
+
+	if s.frontendFailed("setting AGC", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device AGC set to %t", agc)
+
+	return nil
+}
+
+func (s *ServerState) SetDeviceBiasT(biasT bool) error {
+	s.deviceMtx.Lock()
+	err := s.Frontend.SetBiasT(biasT)
+	if err == nil {
+		s.deviceBiasT = biasT
+	}
+	s.deviceMtx.Unlock()
+
+	if s.frontendFailed("setting Bias-T", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device Bias-T set to %t", biasT)
+
+	return nil
+}
+
+// ReadDeviceSetting returns the value of a setting in protocol.ReadableSettings and its text, if any
+func (s *ServerState) ReadDeviceSetting(setting uint32) (uint32, string) {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	switch setting {
+	case protocol.SettingDeviceChannel:
+		var channels, selected = s.deviceChannels()
+		return selected, strings.Join(channels, "\n")
+	case protocol.SettingDeviceAntenna:
+		var antennas, selected = s.deviceAntennas()
+		return selected, strings.Join(antennas, "\n")
+	case protocol.SettingDeviceAGC:
+		return boolToUint32(s.deviceAGC), ""
+	case protocol.SettingDeviceBiasT:
+		return boolToUint32(s.deviceBiasT), ""
+	case protocol.SettingDeviceSampleRate:
+		var sampleRates = make([]string, 0)
+		for _, v := range s.Frontend.GetAvailableSampleRates() {
+			sampleRates = append(sampleRates, strconv.FormatUint(uint64(v), 10))
+		}
+		return s.Frontend.GetSampleRate(), strings.Join(sampleRates, "\n")
+	}
+
+	return 0, ""
+}
+
+func boolToUint32(value bool) uint32 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// GetDeviceInfo returns the DeviceInfo sent to the clients
+func (s *ServerState) GetDeviceInfo() protocol.DeviceInfo {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.deviceInfo
+}
+
+// UpdateDeviceInfo builds the DeviceInfo sent to the clients from the frontend. The sample rate reported is the current
+// one, since clients derive the decimated sample rates from it.
+func (s *ServerState) UpdateDeviceInfo() {
+	s.deviceMtx.Lock()
+	s.updateDeviceInfo()
+	s.deviceMtx.Unlock()
+}
+
+// updateDeviceInfo is UpdateDeviceInfo for callers holding deviceMtx
+func (s *ServerState) updateDeviceInfo() {
+	s.deviceInfo = protocol.DeviceInfo{
+		DeviceType:           s.Frontend.GetDeviceType(),
+		DeviceSerial:         s.Frontend.GetUintDeviceSerial(),
+		MaximumSampleRate:    s.Frontend.GetSampleRate(),
+		MaximumBandwidth:     s.Frontend.GetMaximumBandwidth(),
+		DecimationStageCount: s.Frontend.MaximumDecimationStages(),
+		GainStageCount:       s.Frontend.MaximumGainIndex(),
+		MaximumGainIndex:     0,
+		MinimumFrequency:     s.Frontend.MinimumFrequency(),
+		MaximumFrequency:     s.Frontend.MaximumFrequency(),
+		MinimumIQDecimation:  0,
+		Resolution:           0,
+		ForcedIQFormat:       protocol.StreamFormatFloat,
+	}
+}
+
+// SetDeviceSampleRate changes the frontend sample rate to one of its available rates, then updates the DeviceInfo
+// and every client channel for it
+func (s *ServerState) SetDeviceSampleRate(sampleRate uint32) error {
+	s.deviceMtx.Lock()
+	var available = false
+	for _, v := range s.Frontend.GetAvailableSampleRates() {
+		if v == sampleRate {
+			available = true
+			break
+		}
+	}
+
+	if !available {
+		s.deviceMtx.Unlock()
+		return fmt.Errorf("frontend %s does not support the sample rate %d", s.Frontend.GetShortName(), sampleRate)
+	}
+
+	appliedSampleRate, err := s.Frontend.SetSampleRate(sampleRate)
+	// The sample rate might have changed even if there was an error
+	s.updateDeviceInfo()
+	s.deviceMtx.Unlock()
+
+	for _, v := range s.GetClients() {
+		v.onDeviceSampleRateChanged()
+	}
+
+	if s.frontendFailed("setting the sample rate", err) != nil {
+		return err
+	}
+
+	s.logger(serverLog).Info("Device sample rate set to %d", appliedSampleRate)
+
+	return nil
+}
+
+func (s *ServerState) startFrontend() error {
+	s.deviceMtx.Lock()
+	defer s.deviceMtx.Unlock()
+
+	return s.Frontend.Start()
+}
+
+func (s *ServerState) stopFrontend() error {
+	s.deviceMtx.Lock()
+	defer s.deviceMtx.Unlock()
+
+	return s.Frontend.Stop()
+}
+
+// DeviceName returns the frontend name
+func (s *ServerState) DeviceName() string {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.Frontend.GetName()
+}
+
+// DeviceCenterFrequency returns the frontend center frequency
+func (s *ServerState) DeviceCenterFrequency() uint32 {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.Frontend.GetCenterFrequency()
+}
+
+// DeviceSampleRate returns the frontend sample rate
+func (s *ServerState) DeviceSampleRate() uint32 {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.Frontend.GetSampleRate()
+}
+
+// DeviceGain returns the frontend gain index
+func (s *ServerState) DeviceGain() uint8 {
+	s.deviceMtx.RLock()
+	defer s.deviceMtx.RUnlock()
+
+	return s.Frontend.GetGain()
+}
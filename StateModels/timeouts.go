@@ -0,0 +1,58 @@
+package StateModels
+
+import (
+	"fmt"
+	"github.com/racerxdl/radioserver/protocol"
+	"time"
+)
+
+// ClientTimeouts are the inactivity limits of a client. 0 disables the check.
+type ClientTimeouts struct {
+	// Idle disconnects clients that are not streaming and did not send any command for that long
+	Idle time.Duration
+	// Ping disconnects clients that used to send pings and stopped for that long
+	Ping time.Duration
+	// Write disconnects clients when sending data blocks for that long (the client stopped reading)
+	Write time.Duration
+}
+
+// Disconnect stops the client. Only the first reason is kept.
+func (state *ClientState) Disconnect(reason string) {
+	state.reasonMtx.Lock()
+	if state.DisconnectReason == "" {
+		state.DisconnectReason = reason
+	}
+	state.reasonMtx.Unlock()
+
+	state.SetRunning(false)
+}
+
+func (state *ClientState) GetDisconnectReason() string {
+	state.reasonMtx.Lock()
+	defer state.reasonMtx.Unlock()
+	return state.DisconnectReason
+}
+
+// CheckTimeouts disconnects the client if any of the timeouts expired. Returns false in that case.
+func (state *ClientState) CheckTimeouts() bool {
+	var now = time.Now()
+	var reason = ""
+
+	if state.Timeouts.Idle > 0 && !state.GetCGS().Streaming && now.Sub(state.LastCommandTime) > state.Timeouts.Idle {
+		reason = fmt.Sprintf("idle timeout (no commands for %s)", state.Timeouts.Idle)
+	}
+
+	if state.Timeouts.Ping > 0 && !state.LastPingReceived.IsZero() && now.Sub(state.LastPingReceived) > state.Timeouts.Ping {
+		reason = fmt.Sprintf("ping timeout (no pings for %s)", state.Timeouts.Ping)
+	}
+
+	if reason == "" {
+		return true
+	}
+
+	state.Warn("Disconnecting: %s", reason)
+	state.SendServerMessage(protocol.ServerMessageDisconnected, reason)
+	state.Disconnect(reason)
+
+	return false
+}
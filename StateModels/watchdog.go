@@ -0,0 +1,182 @@
+package StateModels
+
+import (
+	"context"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/protocol"
+	"sync/atomic"
+	"time"
+)
+
+var watchdogLog = SLog.Scope("Watchdog")
+
+// deviceSettings are the frontend settings restored after it is re-opened
+type deviceSettings struct {
+	centerFrequency uint32
+	sampleRate      uint32
+	gain            uint8
+	antenna         string
+	channel         uint32
+	agc             bool
+	biasT           bool
+}
+
+// StartWatchdog checks that the frontend keeps sending samples while there are clients. If no samples arrive for
+// longer than timeout, the frontend is re-opened (Destroy / Init) until it works again and its settings are restored.
+// The watchdog stops when ctx is done.
+func (s *ServerState) StartWatchdog(ctx context.Context, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	s.watchdogTimeout = timeout
+	s.logger(watchdogLog).Info("Frontend timeout: %s", timeout)
+
+	go s.watchdogRoutine(ctx)
+}
+
+// IsFrontendRecovering returns true while the watchdog is re-opening the frontend. Device settings can't be changed
+// during that time.
+func (s *ServerState) IsFrontendRecovering() bool {
+	return atomic.LoadInt32(&s.recovering) != 0
+}
+
+func (s *ServerState) markSamplesReceived() {
+	atomic.StoreInt64(&s.lastSamples, time.Now().UnixNano())
+}
+
+func (s *ServerState) watchdogRoutine(ctx context.Context) {
+	var ticker = time.NewTicker(s.watchdogTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.frontendStalled() {
+				s.recoverFrontend(ctx)
+			}
+		}
+	}
+}
+
+// frontendStalled returns true if the frontend should be running but sent no samples for longer than the timeout
+func (s *ServerState) frontendStalled() bool {
+	s.clientListMtx.Lock()
+	var running = len(s.clients) > 0 && !s.IsFrontendRecovering()
+	s.clientListMtx.Unlock()
+
+	var lastSamples = time.Unix(0, atomic.LoadInt64(&s.lastSamples))
+
+	return running && time.Since(lastSamples) > s.watchdogTimeout
+}
+
+func (s *ServerState) saveDeviceSettings() deviceSettings {
+	var settings = deviceSettings{
+		centerFrequency: s.Frontend.GetCenterFrequency(),
+		sampleRate:      s.Frontend.GetSampleRate(),
+		gain:            s.Frontend.GetGain(),
+		agc:             s.deviceAGC,
+		biasT:           s.deviceBiasT,
+	}
+
+	if selector, ok := s.Frontend.(frontends.AntennaSelector); ok {
+		settings.antenna = selector.GetAntenna()
+	}
+
+	if selector, ok := s.Frontend.(frontends.ChannelSelector); ok {
+		settings.channel = selector.GetChannel()
+	}
+
+	return settings
+}
+
+// restoreDeviceSettings applies the saved settings, failures are kept as frontend errors but don't stop the others
+func (s *ServerState) restoreDeviceSettings(settings deviceSettings) {
+	if selector, ok := s.Frontend.(frontends.ChannelSelector); ok && selector.GetChannel() != settings.channel {
+		_ = s.frontendFailed("restoring the channel", selector.SetChannel(settings.channel))
+	}
+
+	if settings.antenna != "" {
+		_ = s.frontendFailed("restoring the antenna", s.Frontend.SetAntenna(settings.antenna))
+	}
+
+	if s.Frontend.GetSampleRate() != settings.sampleRate {
+		_, err := s.Frontend.SetSampleRate(settings.sampleRate)
+		_ = s.frontendFailed("restoring the sample rate", err)
+	}
+
+	_, err := s.Frontend.SetCenterFrequency(settings.centerFrequency)
+	_ = s.frontendFailed("restoring the frequency", err)
+	_ = s.frontendFailed("restoring the gain", s.Frontend.SetGain(settings.gain))
+
+	if settings.agc {
+		_ = s.frontendFailed("restoring AGC", s.Frontend.SetAGC(true))
+	}
+
+	if settings.biasT {
+		_ = s.frontendFailed("restoring Bias-T", s.Frontend.SetBiasT(true))
+	}
+}
+
+// SendServerMessage sends a MsgTypeServerMessage to every client
+func (s *ServerState) SendServerMessage(code uint32, message string) {
+	for _, v := range s.GetClients() {
+		v.SendServerMessage(code, message)
+	}
+}
+
+// recoverFrontend re-opens the frontend until it works and restores its settings and every client channel. It gives
+// up if ctx is done first.
+func (s *ServerState) recoverFrontend(ctx context.Context) {
+	s.logger(watchdogLog).Error("%s sent no samples for %s. Re-opening it", s.Frontend.GetShortName(), s.watchdogTimeout)
+
+	s.clientListMtx.Lock()
+	atomic.StoreInt32(&s.recovering, 1)
+	s.clientListMtx.Unlock()
+
+	s.setFrontendError(errFrontendStalled, true)
+	s.SendServerMessage(protocol.ServerMessageFrontendLost, "Frontend stopped sending samples. Trying to recover it...")
+
+	s.deviceMtx.Lock()
+	var settings = s.saveDeviceSettings()
+	_ = s.Frontend.Stop()
+	s.deviceMtx.Unlock()
+
+	// Clients can read the frontend between attempts, but never while it is being re-opened
+	var reopen = func() error {
+		s.deviceMtx.Lock()
+		defer s.deviceMtx.Unlock()
+
+		_ = s.Frontend.Destroy()
+		err := s.Frontend.Init(ctx)
+		if err != nil {
+			return err
+		}
+
+		s.restoreDeviceSettings(settings)
+		s.updateDeviceInfo()
+		return nil
+	}
+
+	if !s.tryOpen(reopen, 1) && !s.retryOpen(ctx, reopen, 2) {
+		return
+	}
+
+	s.markSamplesReceived()
+
+	s.clientListMtx.Lock()
+	atomic.StoreInt32(&s.recovering, 0)
+	if len(s.clients) > 0 {
+		_ = s.frontendFailed("starting", s.startFrontend())
+	}
+	s.clientListMtx.Unlock()
+
+	s.logger(watchdogLog).Info("%s recovered", s.Frontend.GetShortName())
+
+	for _, v := range s.GetClients() {
+		v.onFrontendRecovered()
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/StateModels"
+	"net/http"
+	"time"
+)
+
+var adminSlog = SLog.Scope("Admin API")
+
+// adminAuthLog is limited since anyone that reaches the port can send unauthorized requests
+var adminAuthLog = adminSlog.RateLimited(time.Second)
+
+type adminClientInfo struct {
+	UUID           string
+	Name           string
+	Address        string
+	ClientVersion  string
+	ConnectedSince time.Time
+	HasControl     bool
+	LastCommand    time.Time
+	ReceivedBytes  uint64
+	SentBytes      uint64
+}
+
+type adminFrontendInfo struct {
+	Name            string
+	Device          string
+	CenterFrequency uint32
+	SampleRate      uint32
+	Channel         uint32
+	Channels        []string `json:",omitempty"`
+	Clients         int
+	Control         adminControlInfo
+	Available       bool
+	LastError       string     `json:",omitempty"`
+	LastErrorAt     *time.Time `json:",omitempty"`
+}
+
+type adminControlInfo struct {
+	Mode           string
+	Owner          string
+	LeaseExpiresAt *time.Time `json:",omitempty"`
+}
+
+func makeAdminClientInfo(state *StateModels.ClientState) adminClientInfo {
+	receivedBytes, sentBytes, _ := state.GetCounters()
+	return adminClientInfo{
+		UUID:           state.UUID,
+		Name:           state.Name,
+		Address:        state.Addr.String(),
+		ClientVersion:  state.ClientVersion.String(),
+		ConnectedSince: state.ConnectedSince,
+		HasControl:     state.ServerState.HasControl(state),
+		LastCommand:    state.LastCommandTime,
+		ReceivedBytes:  receivedBytes,
+		SentBytes:      sentBytes,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"Error": message})
+}
+
+// adminServerState returns the frontend selected by ?frontend= (the default one if not set). If there is no such
+// frontend it writes the error and returns nil.
+func adminServerState(w http.ResponseWriter, r *http.Request) *StateModels.ServerState {
+	var s = findServerState(r.URL.Query().Get("frontend"))
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, "no such frontend")
+	}
+
+	return s
+}
+
+func adminFrontends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var info = make([]adminFrontendInfo, len(serverStates))
+	for i, s := range serverStates {
+		var channels, channel = s.GetDeviceChannels()
+		info[i] = adminFrontendInfo{
+			Name:            s.Name,
+			Device:          s.DeviceName(),
+			CenterFrequency: s.DeviceCenterFrequency(),
+			SampleRate:      s.DeviceSampleRate(),
+			Channel:         channel,
+			Channels:        channels,
+			Clients:         len(s.GetClients()),
+			Control:         makeAdminControlInfo(s),
+			Available:       s.FrontendUnavailable() == nil,
+		}
+
+		errTime, err := s.LastFrontendError()
+		if err != nil {
+			info[i].LastError = err.Error()
+			info[i].LastErrorAt = &errTime
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func adminClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var s = adminServerState(w, r)
+	if s == nil {
+		return
+	}
+
+	var clients = s.GetClients()
+	var info = make([]adminClientInfo, len(clients))
+	for i, v := range clients {
+		info[i] = makeAdminClientInfo(v)
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func adminDisconnects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var s = adminServerState(w, r)
+	if s == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.GetRecentDisconnects())
+}
+
+func makeAdminControlInfo(s *StateModels.ServerState) adminControlInfo {
+	var mode = s.GetControlMode()
+	var info = adminControlInfo{
+		Mode: StateModels.ControlModeNames[mode],
+	}
+
+	var owner = s.ControlOwner()
+	if owner != nil {
+		info.Owner = owner.UUID
+		if mode == StateModels.ControlModeLease {
+			var expiration = s.ControlLeaseExpiration()
+			info.LeaseExpiresAt = &expiration
+		}
+	}
+
+	return info
+}
+
+// adminControl handles GET (current owner), POST ?uuid= (assign control) and DELETE (revoke control)
+func adminControl(w http.ResponseWriter, r *http.Request) {
+	var s = adminServerState(w, r)
+	if s == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var uuid = r.URL.Query().Get("uuid")
+		var client = s.FindClient(uuid)
+		if client == nil {
+			writeJSONError(w, http.StatusNotFound, "no such client")
+			return
+		}
+		if !s.AssignControl(client) {
+			writeJSONError(w, http.StatusConflict, "control mode does not allow assigning control")
+			return
+		}
+		adminSlog.Info("Control assigned to %s from %s", client.UUID, r.RemoteAddr)
+	case http.MethodDelete:
+		s.RevokeControl()
+		adminSlog.Info("Control revoked from %s", r.RemoteAddr)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, makeAdminControlInfo(s))
+}
+
+type adminLogInfo struct {
+	Level  string
+	Scopes map[string]string
+}
+
+func makeAdminLogInfo() adminLogInfo {
+	var info = adminLogInfo{
+		Level:  SLog.GetGlobalLevel().String(),
+		Scopes: map[string]string{},
+	}
+
+	for scope, level := range SLog.GetScopeLevels() {
+		info.Scopes[scope] = level.String()
+	}
+
+	return info
+}
+
+// adminLog handles GET (current levels), POST ?level= (global level), POST ?scope=&level= (level of a scope) and
+// DELETE ?scope= (the scope uses the global level again)
+func adminLog(w http.ResponseWriter, r *http.Request) {
+	var scope = r.URL.Query().Get("scope")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level, err := SLog.ParseLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if scope == "" {
+			SLog.SetLevel(level)
+			adminSlog.Info("Log level set to %s from %s", level, r.RemoteAddr)
+		} else {
+			SLog.SetScopeLevel(scope, level)
+			adminSlog.Info("Log level of %s set to %s from %s", scope, level, r.RemoteAddr)
+		}
+	case http.MethodDelete:
+		if scope == "" {
+			writeJSONError(w, http.StatusBadRequest, "scope is required")
+			return
+		}
+		SLog.ClearScopeLevel(scope)
+		adminSlog.Info("Log level of %s cleared from %s", scope, r.RemoteAddr)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, makeAdminLogInfo())
+}
+
+// adminAuth rejects the requests without the token. An empty token allows every request, the API only listens on
+// loopback addresses then.
+func adminAuth(token string, next http.Handler) http.Handler {
+	var expected = []uint8("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]uint8(r.Header.Get("Authorization")), expected) != 1 {
+			adminAuthLog.Warn("Unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="radioserver"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func createAdminHandler(token string) http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/clients", adminClients)
+	mux.HandleFunc("/control", adminControl)
+	mux.HandleFunc("/disconnects", adminDisconnects)
+	mux.HandleFunc("/frontends", adminFrontends)
+	mux.HandleFunc("/log", adminLog)
+
+	return adminAuth(token, mux)
+}
+
+func runAdminServer(config AdminConfig) {
+	address, err := config.ListenAddress()
+	if err != nil {
+		adminSlog.Error("Admin API not started: %s", err)
+		return
+	}
+
+	adminSlog.Info("Admin API listening at %s", address)
+	err = http.ListenAndServe(address, createAdminHandler(config.Token))
+	if err != nil {
+		adminSlog.Error("Admin API error: %s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminListenAddress(t *testing.T) {
+	var cases = []struct {
+		config   AdminConfig
+		expected string
+	}{
+		{AdminConfig{Address: ":5580"}, "127.0.0.1:5580"},
+		{AdminConfig{Address: "localhost:5580"}, "localhost:5580"},
+		{AdminConfig{Address: "[::1]:5580"}, "[::1]:5580"},
+		{AdminConfig{Address: "0.0.0.0:5580", Token: "secret"}, "0.0.0.0:5580"},
+	}
+
+	for _, c := range cases {
+		address, err := c.config.ListenAddress()
+		if err != nil || address != c.expected {
+			t.Errorf("%+v: expected %s got %s (%v)", c.config, c.expected, address, err)
+		}
+	}
+
+	for _, address := range []string{"0.0.0.0:5580", "192.168.0.10:5580", "example.com:5580", "5580"} {
+		var config = AdminConfig{Address: address}
+		if _, err := config.ListenAddress(); err == nil {
+			t.Errorf("%s: expected an error without a token", address)
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	setupTestServer()
+	var handler = createAdminHandler("secret")
+
+	for header, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		var r = httptest.NewRequest(http.MethodGet, "/frontends", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		var w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != expected {
+			t.Errorf("%q: expected %d got %d", header, expected, w.Code)
+		}
+	}
+
+	// Without a token (only on loopback) every request is allowed
+	var w = httptest.NewRecorder()
+	createAdminHandler("").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/frontends", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d without a token got %d", http.StatusOK, w.Code)
+	}
+}
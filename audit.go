@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/protocol"
+	"time"
+)
+
+// auditScope is the scope of the audit trail: client connections, hellos and setting changes. Messages carry the
+// client uuid, address and frontend, and can be written to a file of their own (see LogConfig.Audit).
+const auditScope = "Audit"
+
+func auditLog(state *StateModels.ClientState, event string) *SLog.Instance {
+	return state.LogInstance.Scope(auditScope).With("event", event)
+}
+
+func auditConnect(state *StateModels.ClientState, l *serverListener) {
+	auditLog(state, "connect").
+		With("listener", l.Addr().String()).
+		Info("Client connected")
+}
+
+func auditDisconnect(state *StateModels.ClientState) {
+	auditLog(state, "disconnect").
+		With("client", state.Name).
+		With("reason", state.GetDisconnectReason()).
+		With("duration", time.Since(state.ConnectedSince).Round(time.Second).String()).
+		Info("Client disconnected")
+}
+
+func auditHello(state *StateModels.ClientState) {
+	auditLog(state, "hello").
+		With("client", state.Name).
+		With("version", state.ClientVersion.String()).
+		Info("Hello from %s %s", state.Name, state.ClientVersion.String())
+}
+
+// auditSetting records a setting change requested by a client. result is empty when it was applied, otherwise why it
+// wasn't. Device settings are logged as info and client settings as debug, since clients change them all the time.
+func auditSetting(state *StateModels.ClientState, setting, requested, oldValue uint32, result string) {
+	var settingName = protocol.SettingNames[setting]
+	var newValue, _ = state.SettingValue(setting)
+
+	var log = auditLog(state, "setting").
+		With("client", state.Name).
+		With("setting", settingName).
+		With("requested", requested).
+		With("old", oldValue).
+		With("new", newValue)
+
+	var logFunc = log.Debug
+	if protocol.SettingAffectsGlobal(setting) {
+		logFunc = log.Info
+	}
+
+	if result != "" {
+		logFunc("%s not changed: %s", settingName, result)
+		return
+	}
+
+	logFunc("%s changed from %d to %d", settingName, oldValue, newValue)
+}
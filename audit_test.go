@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/protocol"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buff = bytes.NewBuffer(nil)
+	var sink = &SLog.Sink{Writer: buff, Format: SLog.FormatJSON, Level: SLog.LevelDebug, Scopes: []string{auditScope}}
+	SLog.AddSink(sink)
+	defer SLog.RemoveSink(sink)
+
+	var cc = startConformanceClient(t)
+	var model = createConformanceModel()
+	cc.send(makeHello("Conformance"), math.MaxInt32)
+	cc.expect(model.hello())
+
+	cc.send(makeSetSetting(protocol.SettingDeviceFrequency, mockCenterFrequency+1000000), math.MaxInt32)
+	model.deviceFrequency = mockCenterFrequency + 1000000
+	// Both channels are outside the new window and are moved to the device frequency
+	model.iqFrequency = model.deviceFrequency
+	model.fftFrequency = model.deviceFrequency
+	cc.expect(model.sync())
+	cc.close()
+
+	var events = make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buff.String()), "\n") {
+		var event map[string]interface{}
+		err := json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			t.Fatalf("expected a JSON line got %q: %s", line, err)
+		}
+		events = append(events, event)
+	}
+
+	var expected = []map[string]interface{}{
+		{"event": "connect"},
+		{"event": "hello", "client": "Conformance"},
+		{"event": "setting", "setting": "Device Frequency", "old": float64(mockCenterFrequency), "new": float64(mockCenterFrequency + 1000000)},
+		{"event": "disconnect", "client": "Conformance"},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d audit events got %v", len(expected), events)
+	}
+
+	for i, fields := range expected {
+		if events[i]["uuid"] == nil || events[i]["address"] == nil {
+			t.Errorf("expected the client uuid and address in %v", events[i])
+		}
+		for k, v := range fields {
+			if events[i][k] != v {
+				t.Errorf("expected %s to be %v in %v", k, v, events[i])
+			}
+		}
+	}
+}
@@ -6,57 +6,108 @@ import (
 	"time"
 )
 
-func RunCmdHello(state *StateModels.ClientState) {
-	version, name := protocol.ParseCmdHelloBody(state.CmdBody)
+func RunCmdHello(state *StateModels.ClientState) error {
+	version, name, err := protocol.ParseCmdHelloBody(state.CmdBody)
+	if err != nil {
+		return err
+	}
+
 	state.Info("Received Hello: %s - %s", version.String(), name)
 	state.Name = name
 	state.ClientVersion = version
+	auditHello(state)
 
-	data := StateModels.CreateDeviceInfo(state)
-	if !state.SendData(data) {
-		state.Error("Error sending deviceInfo packet")
-	}
-
+	state.SendDeviceInfo()
 	state.SendSync()
+	return nil
 }
 
-func RunCmdGetSetting(state *StateModels.ClientState) {
-	// TODO
-	state.Warn("!!!! RunCmdGetSetting not implemented !!!!")
+func RunCmdGetSetting(state *StateModels.ClientState) error {
+	setting, err := protocol.ParseCmdGetSettingBody(state.CmdBody)
+	if err != nil {
+		return err
+	}
+
+	if !protocol.IsSettingReadable(setting) {
+		// Not a protocol error, newer clients might know settings we don't
+		state.Warn("Setting %d can't be read", setting)
+		return nil
+	}
+
+	value, text := state.ServerState.ReadDeviceSetting(setting)
+	state.Debug("Get Setting: %s => %d", protocol.SettingNames[setting], value)
+	state.SendReadSetting(setting, value, text)
+
+	return nil
 }
 
-func RunCmdSetSetting(state *StateModels.ClientState) {
-	setting, args := protocol.ParseCmdSetSettingBody(state.CmdBody)
+func RunCmdSetSetting(state *StateModels.ClientState) error {
+	setting, args, err := protocol.ParseCmdSetSettingBody(state.CmdBody)
+	if err != nil {
+		return err
+	}
+
+	if setting == protocol.SettingDigitalGain {
+		// SDR++ sends it along with the gain. There's no digital gain in the server, so it's ignored.
+		state.Debug("Ignoring %s => %d", protocol.SettingNames[setting], args)
+		return nil
+	}
 
 	if !protocol.IsSettingPossible(setting) {
-		state.Error("Invalid Setting %d", setting)
-		return
+		// Not a protocol error, newer clients might know settings we don't
+		state.Warn("Unknown Setting %d", setting)
+		return nil
 	}
 
 	settingName := protocol.SettingNames[setting]
 	state.Debug("Set Setting: %s => %d", settingName, args)
 
-	currentStreaming := state.CGS.Streaming
+	oldValue, _ := state.SettingValue(setting)
+
+	if protocol.SettingAffectsGlobal(setting) && !state.ServerState.HasControl(state) {
+		state.Warn("Rejecting %s change: client does not have control", settingName)
+		auditSetting(state, setting, args[0], oldValue, "client does not have control")
+		state.SendSync()
+		return nil
+	}
+
+	currentStreaming := state.GetCGS().Streaming
 
 	if !state.SetSetting(setting, args) {
-		return
+		auditSetting(state, setting, args[0], oldValue, "rejected")
+		// Let the client know the setting was not applied
+		state.SendSync()
+		return nil
 	}
 
-	if currentStreaming || currentStreaming != state.CGS.Streaming {
+	auditSetting(state, setting, args[0], oldValue, "")
+
+	if currentStreaming || currentStreaming != state.GetCGS().Streaming {
 		state.CG.UpdateSettings(state)
 		state.SendSync()
+	} else if protocol.SettingAffectsSync(setting) {
+		// Reply with the applied value, it might have been clamped
+		state.SendSync()
 	}
 
 	if protocol.SettingAffectsGlobal(setting) {
-		serverState.SendSync()
+		state.ServerState.SendSync()
 	}
+
+	return nil
 }
 
-func RunCmdPing(state *StateModels.ClientState) {
-	timestamp := protocol.ParseCmdPingBody(state.CmdBody)
+func RunCmdPing(state *StateModels.ClientState) error {
+	timestamp, err := protocol.ParseCmdPingBody(state.CmdBody)
+	if err != nil {
+		return err
+	}
+
 	delta := float64(time.Now().UnixNano()-timestamp) / 1e6
 	state.Debug("Received PING %.2f ms", delta)
 
 	state.LastPingTime = timestamp
+	state.LastPingReceived = time.Now()
 	state.SendPong()
+	return nil
 }
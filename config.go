@@ -0,0 +1,537 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/protocol"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const defaultTLSPort = protocol.DefaultPort + 1
+
+const defaultWriteTimeoutSeconds = 10
+
+type TLSConfig struct {
+	// Port is only used with the top level Port setting. Listeners have their own Address.
+	Port              int
+	CertificateFile   string
+	KeyFile           string
+	ClientCAFile      string
+	RequireClientCert bool
+}
+
+type ControlConfig struct {
+	// Mode is one of none, firstcome, admin or lease
+	Mode         string
+	LeaseSeconds int
+}
+
+type AdminConfig struct {
+	// Address where the admin HTTP API listens, for example 127.0.0.1:5580. Without a host (:5580) it only listens on
+	// localhost. Empty disables it.
+	Address string
+	// Token must be sent by every request as "Authorization: Bearer <token>". Required to listen on an address that is
+	// not loopback.
+	Token string
+}
+
+// ListenAddress returns the address to listen on, localhost if Address has no host
+func (c *AdminConfig) ListenAddress() (string, error) {
+	host, port, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return "", fmt.Errorf("invalid admin address %q: %s", c.Address, err)
+	}
+
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	var ip = net.ParseIP(host)
+	if c.Token == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("the admin API needs a Token to listen at %s", c.Address)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+type LogConfig struct {
+	// Format is auto (colored text on a terminal, plain text otherwise), text, plain or json
+	Format string
+	// Level is the minimum level written: debug, info (default), warn or error
+	Level string
+	// Scopes overrides Level for some scopes, for example {"Client": "warn"}
+	Scopes map[string]string
+	// File also writes every message to a rotating file. Levels and Scopes don't apply to it.
+	File *LogFileConfig
+	// Audit writes client connections, hellos and setting changes to a rotating file of their own
+	Audit *LogFileConfig
+}
+
+type LogFileConfig struct {
+	Path string
+	// Format is plain or json (default plain for File and json for Audit)
+	Format string
+	// Level is the minimum level written (default debug)
+	Level string
+	// MaxSizeMB and MaxAgeHours rotate the file when it gets bigger or older. 0 disables them.
+	MaxSizeMB   int
+	MaxAgeHours int
+	// MaxBackups is the number of rotated files kept. 0 keeps them all.
+	MaxBackups int
+}
+
+// sink validates the config and builds its sink, without opening the file
+func (c *LogFileConfig) sink(defaultFormat SLog.Format) (*SLog.Sink, error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("log file without Path")
+	}
+
+	var sink = &SLog.Sink{Format: defaultFormat, Level: SLog.LevelDebug}
+
+	var err error
+	if c.Format != "" {
+		sink.Format, err = SLog.ParseFormat(c.Format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Level != "" {
+		sink.Level, err = SLog.ParseLevel(c.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+func (c *LogFileConfig) open(sink *SLog.Sink) (*SLog.RotatingFile, error) {
+	file, err := SLog.OpenRotatingFile(c.Path, int64(c.MaxSizeMB)*1024*1024, time.Duration(c.MaxAgeHours)*time.Hour, c.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	sink.Writer = file
+
+	return file, nil
+}
+
+// parse validates the log config
+func (c *LogConfig) parse() (SLog.Format, SLog.Level, map[string]SLog.Level, error) {
+	format, err := SLog.ParseFormat(c.Format)
+	if err != nil {
+		return format, SLog.LevelDebug, nil, err
+	}
+
+	var level = SLog.LevelInfo
+	if c.Level != "" {
+		level, err = SLog.ParseLevel(c.Level)
+		if err != nil {
+			return format, level, nil, err
+		}
+	}
+
+	var scopeLevels = map[string]SLog.Level{}
+	for scope, name := range c.Scopes {
+		scopeLevels[scope], err = SLog.ParseLevel(name)
+		if err != nil {
+			return format, level, nil, fmt.Errorf("scope %s: %s", scope, err)
+		}
+	}
+
+	if c.File != nil {
+		_, err = c.File.sink(SLog.FormatPlain)
+		if err != nil {
+			return format, level, nil, fmt.Errorf("log file: %s", err)
+		}
+	}
+
+	if c.Audit != nil {
+		_, err = c.Audit.sink(SLog.FormatJSON)
+		if err != nil {
+			return format, level, nil, fmt.Errorf("audit log: %s", err)
+		}
+	}
+
+	return format, level, scopeLevels, nil
+}
+
+// Apply sets the SLog format and levels and opens the log files. The returned function closes them.
+func (c *LogConfig) Apply() (func(), error) {
+	format, level, scopeLevels, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	SLog.SetFormat(format)
+	SLog.SetLevel(level)
+	for scope, scopeLevel := range scopeLevels {
+		SLog.SetScopeLevel(scope, scopeLevel)
+	}
+
+	var sinks []*SLog.Sink
+	var files []*SLog.RotatingFile
+	var closeFiles = func() {
+		for _, sink := range sinks {
+			SLog.RemoveSink(sink)
+		}
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}
+
+	var add = func(fileConfig *LogFileConfig, defaultFormat SLog.Format, scopes ...string) error {
+		if fileConfig == nil {
+			return nil
+		}
+
+		sink, _ := fileConfig.sink(defaultFormat)
+		sink.Scopes = scopes
+		file, err := fileConfig.open(sink)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file)
+		sinks = append(sinks, sink)
+		SLog.AddSink(sink)
+
+		return nil
+	}
+
+	err = add(c.File, SLog.FormatPlain)
+	if err == nil {
+		err = add(c.Audit, SLog.FormatJSON, auditScope)
+	}
+
+	if err != nil {
+		closeFiles()
+		return nil, err
+	}
+
+	return closeFiles, nil
+}
+
+type LimitsConfig struct {
+	// MaxClients is the maximum number of simultaneous clients. 0 means unlimited
+	MaxClients int
+	// MaxClientsPerIP is the maximum number of simultaneous clients from a single IP. 0 means unlimited
+	MaxClientsPerIP int
+	// Allow is a list of CIDRs allowed to connect. Empty allows everyone not in Deny
+	Allow []string
+	// Deny is a list of CIDRs that are not allowed to connect
+	Deny []string
+	// BanAfterErrors bans an IP after that many protocol errors. 0 disables it
+	BanAfterErrors int
+	// BanSeconds is how long a ban lasts
+	BanSeconds int
+	// RejectMessage is sent to clients rejected because of MaxClients or MaxClientsPerIP
+	RejectMessage string
+	// MaxClientBandwidth is the maximum IQ output of a single client in bytes per second. 0 means unlimited
+	MaxClientBandwidth uint64
+	// MaxBandwidth is the maximum IQ output of all clients together in bytes per second. 0 means unlimited
+	MaxBandwidth uint64
+	// BandwidthPolicy is "decimate" (default) to raise the decimation of clients above the limits or "reject"
+	BandwidthPolicy string
+}
+
+type RtlTcpConfig struct {
+	// Frequency is the initial channel center frequency. 0 uses the device center frequency
+	Frequency uint32
+	// Decimation is the initial channel decimation stage
+	Decimation uint32
+}
+
+type ListenerConfig struct {
+	// Address to bind, for example ":5555", "192.168.0.10:5555" or "[2001:db8::1]:5555"
+	Address string
+	// Network is "tcp" (dual-stack, default), "tcp4" or "tcp6" (IPv6 only)
+	Network string
+	// Protocol is "spyserver" (default) or "rtltcp" to serve a single channel to rtl_tcp clients
+	Protocol string
+	// Frontend is the name of the frontend served by this listener. Empty serves the first one
+	Frontend string
+	TLS      *TLSConfig
+	RtlTcp   RtlTcpConfig
+	LimitsConfig
+}
+
+type TimeoutsConfig struct {
+	// IdleSeconds disconnects clients that are not streaming and sent no commands for that long. 0 disables it
+	IdleSeconds int
+	// PingSeconds disconnects clients that sent pings before and stopped for that long. 0 disables it
+	PingSeconds int
+	// WriteSeconds disconnects clients that stopped reading, when a write blocks for that long. 0 disables it
+	WriteSeconds int
+	// KeepAliveSeconds is the TCP keepalive probe period. 0 uses the system default, negative disables it
+	KeepAliveSeconds int
+}
+
+type CorrectionConfig struct {
+	// PPM is the frequency error of the device oscillator in parts per million
+	PPM float64
+	// RemoveDC removes the DC spike at the center of the spectrum
+	RemoveDC bool
+	// CorrectIQ corrects the IQ imbalance that shows as mirror images of strong signals
+	CorrectIQ bool
+}
+
+type FrontendConfig struct {
+	// Name identifies the frontend in the listeners and in the admin API. Required when there is more than one
+	Name string
+	// Type is airspy, limesdr, rtlsdr, hackrf, spyserver or rtltcp. Hardware frontends are only available when built
+	// with their build tag. Empty uses the first hardware frontend in the build, in that order.
+	Type string
+	// DeviceIndex selects the device when more than one is connected
+	DeviceIndex int
+	// Channel selects the RX channel in devices with more than one (limesdr)
+	Channel int
+	// Antenna selects the antenna input by name in devices with more than one (limesdr). Empty uses the default
+	Antenna string
+	// SampleRate is the device sample rate in Hz, one of the rates supported by the frontend. 0 uses the frontend
+	// default (usually its maximum)
+	SampleRate uint32
+	// CenterFrequency is the initial center frequency in Hz
+	CenterFrequency uint32
+	// Address of the upstream server (host:port) for the spyserver and rtltcp frontends
+	Address string
+	// IQFormat requested from the upstream server for the spyserver frontend: uint8, int16 (default) or float
+	IQFormat string
+	// Correction applied to the samples and frequencies before they reach the clients
+	Correction CorrectionConfig
+	// WatchdogSeconds re-opens the frontend when it sends no samples for that long while there are clients.
+	// 0 disables it
+	WatchdogSeconds int
+	// RetrySeconds is the time between attempts to open the frontend while it is not available
+	RetrySeconds int
+}
+
+type ServerConfig struct {
+	// Port and TLS are a shortcut for a single plaintext listener in all IPv4 interfaces (plus an optional TLS one).
+	// They're ignored if Listeners is set.
+	Port      int
+	TLS       *TLSConfig
+	Listeners []ListenerConfig
+	Limits    LimitsConfig
+	Timeouts  TimeoutsConfig
+	Control   ControlConfig
+	Admin     AdminConfig
+	Log       LogConfig
+	// Frontend is the single frontend of the server. It's ignored if Frontends is set.
+	Frontend FrontendConfig
+	// Frontends runs more than one frontend in the same server, each with its own clients and control owner
+	Frontends []FrontendConfig
+}
+
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Port: protocol.DefaultPort,
+		// Only the write timeout is on by default, so a client that stops reading can't stall the server.
+		// The others are disabled unless configured, so existing setups keep their clients connected
+		Timeouts: TimeoutsConfig{
+			WriteSeconds: defaultWriteTimeoutSeconds,
+		},
+		Control: ControlConfig{
+			Mode: "none",
+		},
+		Frontend: DefaultFrontendConfig(),
+	}
+}
+
+func DefaultFrontendConfig() FrontendConfig {
+	return FrontendConfig{
+		CenterFrequency: 106300000,
+		IQFormat:        "int16",
+		WatchdogSeconds: 10,
+		RetrySeconds:    5,
+	}
+}
+
+// UnmarshalJSON starts from DefaultFrontendConfig, so the entries of Frontends get the same defaults as Frontend
+func (c *FrontendConfig) UnmarshalJSON(data []byte) error {
+	type plainFrontendConfig FrontendConfig
+	var config = plainFrontendConfig(DefaultFrontendConfig())
+
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return err
+	}
+
+	*c = FrontendConfig(config)
+	return nil
+}
+
+func (c *TimeoutsConfig) ClientTimeouts() StateModels.ClientTimeouts {
+	return StateModels.ClientTimeouts{
+		Idle:  time.Duration(c.IdleSeconds) * time.Second,
+		Ping:  time.Duration(c.PingSeconds) * time.Second,
+		Write: time.Duration(c.WriteSeconds) * time.Second,
+	}
+}
+
+func (c *TimeoutsConfig) KeepAlive() time.Duration {
+	if c.KeepAliveSeconds < 0 {
+		return -1
+	}
+
+	return time.Duration(c.KeepAliveSeconds) * time.Second
+}
+
+func LoadServerConfig(filename string) (*ServerConfig, error) {
+	var config = DefaultServerConfig()
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+
+	if config.TLS != nil && config.TLS.Port == 0 {
+		config.TLS.Port = defaultTLSPort
+	}
+
+	if _, _, _, err := config.Log.parse(); err != nil {
+		return nil, err
+	}
+
+	if config.Admin.Address != "" {
+		if _, err := config.Admin.ListenAddress(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !isValidBandwidthPolicy(config.Limits.BandwidthPolicy) {
+		return nil, fmt.Errorf("invalid bandwidth policy %q", config.Limits.BandwidthPolicy)
+	}
+
+	var frontendNames = map[string]bool{}
+	for i, f := range config.GetFrontends() {
+		if _, ok := iqFormats[f.IQFormat]; !ok {
+			return nil, fmt.Errorf("invalid frontend iq format %q", f.IQFormat)
+		}
+		if len(config.Frontends) > 1 && f.Name == "" {
+			return nil, fmt.Errorf("frontend %d has no name", i)
+		}
+		if frontendNames[f.Name] {
+			return nil, fmt.Errorf("duplicated frontend name %q", f.Name)
+		}
+		frontendNames[f.Name] = true
+	}
+
+	for i := range config.Listeners {
+		var l = &config.Listeners[i]
+		if !isValidBandwidthPolicy(l.BandwidthPolicy) {
+			return nil, fmt.Errorf("invalid bandwidth policy %q for listener %s", l.BandwidthPolicy, l.Address)
+		}
+		if l.Network == "" {
+			l.Network = "tcp"
+		}
+		if l.Network != "tcp" && l.Network != "tcp4" && l.Network != "tcp6" {
+			return nil, fmt.Errorf("invalid network %q for listener %s", l.Network, l.Address)
+		}
+		if l.Address == "" {
+			return nil, fmt.Errorf("listener %d has no address", i)
+		}
+		if l.Protocol == "" {
+			l.Protocol = "spyserver"
+		}
+		if l.Protocol != "spyserver" && l.Protocol != "rtltcp" {
+			return nil, fmt.Errorf("invalid protocol %q for listener %s", l.Protocol, l.Address)
+		}
+		if l.Frontend != "" && !frontendNames[l.Frontend] {
+			return nil, fmt.Errorf("unknown frontend %q for listener %s", l.Frontend, l.Address)
+		}
+	}
+
+	return config, nil
+}
+
+// iqFormats are the IQ formats that can be requested from an upstream server
+var iqFormats = map[string]uint32{
+	"uint8": protocol.StreamFormatUint8,
+	"int16": protocol.StreamFormatInt16,
+	"float": protocol.StreamFormatFloat,
+}
+
+func isValidBandwidthPolicy(policy string) bool {
+	return policy == "" || policy == "decimate" || policy == "reject"
+}
+
+// GetFrontends returns the configured frontends, Frontend alone if Frontends was not set
+func (c *ServerConfig) GetFrontends() []FrontendConfig {
+	if len(c.Frontends) > 0 {
+		return c.Frontends
+	}
+
+	return []FrontendConfig{c.Frontend}
+}
+
+// GetListeners returns the configured listeners, building them from Port / TLS if none were set
+func (c *ServerConfig) GetListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+
+	var listeners = []ListenerConfig{
+		{
+			Address: fmt.Sprintf(":%d", c.Port),
+			Network: "tcp4",
+		},
+	}
+
+	if c.TLS != nil {
+		listeners = append(listeners, ListenerConfig{
+			Address: fmt.Sprintf(":%d", c.TLS.Port),
+			Network: "tcp4",
+			TLS:     c.TLS,
+		})
+	}
+
+	return listeners
+}
+
+// BuildTLSConfig loads the certificate / key pair and, if a client CA is set, enables client certificate verification
+func (c *TLSConfig) BuildTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertificateFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %s", err)
+	}
+
+	var tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client CA: %s", err)
+		}
+
+		var pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found at %s", c.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if c.RequireClientCert {
+		return nil, fmt.Errorf("RequireClientCert is set but no ClientCAFile was given")
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+const conformanceReadTimeout = 5 * time.Second
+
+// region Test Transport
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeListener is only used to satisfy serverListener, connections are handed directly to handleConnection
+type pipeListener struct{}
+
+func (pipeListener) Accept() (net.Conn, error) { return nil, errors.New("not supported") }
+func (pipeListener) Close() error              { return nil }
+func (pipeListener) Addr() net.Addr            { return pipeAddr{} }
+
+type conformanceClient struct {
+	t        *testing.T
+	conn     net.Conn
+	frontend *mockFrontend
+	writes   chan []uint8
+	done     chan bool
+}
+
+// startConformanceClient runs handleConnection against a mockFrontend and returns the client side of the connection
+func startConformanceClient(t *testing.T) *conformanceClient {
+	var frontend = setupTestServer()
+	globalGuard, _ = createConnectionGuard(&LimitsConfig{})
+
+	var l = &serverListener{
+		Listener: pipeListener{},
+		config:   &ListenerConfig{},
+	}
+	l.guard, _ = createConnectionGuard(&l.config.LimitsConfig)
+
+	server, client := net.Pipe()
+	_, _ = l.admit(remoteIP(server.RemoteAddr()))
+
+	var cc = &conformanceClient{
+		t:        t,
+		conn:     client,
+		frontend: frontend,
+		writes:   make(chan []uint8, 1024),
+		done:     make(chan bool),
+	}
+
+	go func() {
+		handleConnection(server, l)
+		close(cc.done)
+	}()
+
+	// Writes are done in another goroutine since net.Pipe is synchronous and the server might be blocked sending replies
+	go func() {
+		for data := range cc.writes {
+			_, err := client.Write(data)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return cc
+}
+
+// send writes the data in chunks of the specified size. Each chunk is a separate read in the server.
+func (cc *conformanceClient) send(data []uint8, chunkSize int) {
+	for len(data) > 0 {
+		var n = chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		cc.writes <- data[:n]
+		data = data[n:]
+	}
+}
+
+func (cc *conformanceClient) read(size int) []uint8 {
+	var data = make([]uint8, size)
+	_ = cc.conn.SetReadDeadline(time.Now().Add(conformanceReadTimeout))
+	_, err := io.ReadFull(cc.conn, data)
+	if err != nil {
+		cc.t.Fatalf("error reading %d bytes: %s", size, err)
+	}
+
+	return data
+}
+
+func (cc *conformanceClient) expect(expected []uint8) {
+	var received = cc.read(len(expected))
+	if bytes.Equal(received, expected) {
+		return
+	}
+
+	for i := range expected {
+		if received[i] != expected[i] {
+			cc.t.Fatalf("received data differs at byte %d\nexpected: %x\nreceived: %x", i, expected, received)
+		}
+	}
+}
+
+// waitSentPackets waits the server to account for the packets already read. net.Pipe writes return as soon as the
+// data is read, so the sequence number of data packets generated right after could still be the old one.
+func (cc *conformanceClient) waitSentPackets(count uint64) {
+	var deadline = time.Now().Add(conformanceReadTimeout)
+	for time.Now().Before(deadline) {
+		var clients = serverState.GetClients()
+		if len(clients) > 0 {
+			if _, _, sentPackets := clients[0].GetCounters(); sentPackets >= count {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cc.t.Fatalf("server did not send %d packets", count)
+}
+
+func (cc *conformanceClient) close() {
+	close(cc.writes)
+	_ = cc.conn.Close()
+
+	select {
+	case <-cc.done:
+	case <-time.After(conformanceReadTimeout):
+		cc.t.Fatalf("handleConnection did not return after the client closed the connection")
+	}
+}
+
+// endregion
+// region Expected Messages
+
+// makeMessage builds a message without the StateModels generators, so the suite catches changes in them
+func makeMessage(messageType, streamType, sequence uint32, body []uint8) []uint8 {
+	var header = make([]uint8, 20)
+	binary.LittleEndian.PutUint32(header[0:], ServerVersion.ToUint32())
+	binary.LittleEndian.PutUint32(header[4:], messageType)
+	binary.LittleEndian.PutUint32(header[8:], streamType)
+	binary.LittleEndian.PutUint32(header[12:], sequence)
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(body)))
+
+	return append(header, body...)
+}
+
+func uint32sToBytes(values ...uint32) []uint8 {
+	var data = make([]uint8, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+
+	return data
+}
+
+func expectedDeviceInfo(sequence, sampleRate uint32) []uint8 {
+	return makeMessage(protocol.MsgTypeDeviceInfo, protocol.StreamTypeStatus, sequence, uint32sToBytes(
+		protocol.DeviceAirspyOne, // DeviceType
+		0xcafe,                   // DeviceSerial
+		sampleRate,               // MaximumSampleRate
+		sampleRate*8/10,          // MaximumBandwidth
+		8,                        // DecimationStageCount
+		16,                       // GainStageCount
+		0,                        // MaximumGainIndex
+		24e6,                     // MinimumFrequency
+		1.8e9,                    // MaximumFrequency
+		0,                        // Resolution
+		0,                        // MinimumIQDecimation
+		protocol.StreamFormatFloat,
+	))
+}
+
+// conformanceModel is what the client state is expected to be after each command
+type conformanceModel struct {
+	sequence        uint32
+	canControl      uint32
+	gain            uint32
+	deviceFrequency uint32
+	iqFrequency     uint32
+	iqDecimation    uint32
+	fftFrequency    uint32
+	fftDecimation   uint32
+}
+
+func createConformanceModel() *conformanceModel {
+	return &conformanceModel{
+		canControl:      1,
+		deviceFrequency: mockCenterFrequency,
+		iqFrequency:     mockCenterFrequency,
+		fftFrequency:    mockCenterFrequency,
+	}
+}
+
+func (m *conformanceModel) nextSequence() uint32 {
+	var sequence = m.sequence
+	m.sequence++
+	return sequence
+}
+
+func (m *conformanceModel) window(decimation uint32) (uint32, uint32) {
+	var halfSpan = uint32(mockSampleRate-mockSampleRate>>decimation) / 2
+	return m.deviceFrequency - halfSpan, m.deviceFrequency + halfSpan
+}
+
+func (m *conformanceModel) sync() []uint8 {
+	var minIQ, maxIQ = m.window(m.iqDecimation)
+	var minFFT, maxFFT = m.window(m.fftDecimation)
+
+	return makeMessage(protocol.MsgTypeClientSync, protocol.StreamTypeStatus, m.nextSequence(), uint32sToBytes(
+		m.canControl,
+		m.gain,
+		m.deviceFrequency,
+		m.iqFrequency,
+		m.fftFrequency,
+		minIQ,
+		maxIQ,
+		minFFT,
+		maxFFT,
+	))
+}
+
+func (m *conformanceModel) hello() []uint8 {
+	var data = expectedDeviceInfo(m.nextSequence(), mockSampleRate)
+	return append(data, m.sync()...)
+}
+
+// endregion
+
+type conformanceStep struct {
+	name    string
+	command []uint8
+	// apply updates the model and returns the expected reply
+	apply func(m *conformanceModel) []uint8
+}
+
+var noReply = func(m *conformanceModel) []uint8 { return nil }
+var syncReply = func(m *conformanceModel) []uint8 { return m.sync() }
+
+// deviceErrorReply is the reply to a device setting that the server or the frontend rejected
+func deviceErrorReply(message string) func(m *conformanceModel) []uint8 {
+	return func(m *conformanceModel) []uint8 {
+		var reply = makeMessage(protocol.MsgTypeServerMessage, protocol.StreamTypeStatus, m.nextSequence(), append(uint32sToBytes(protocol.ServerMessageDeviceError), []uint8(message)...))
+		return append(reply, m.sync()...)
+	}
+}
+
+// conformanceSteps goes through every command and setting. Settings are only acknowledged with a sync when they
+// change what is reported in it, when they are rejected or when the client is streaming.
+var conformanceSteps = []conformanceStep{
+	{"hello", makeHello("Conformance"), func(m *conformanceModel) []uint8 { return m.hello() }},
+	{"iq format", makeSetSetting(protocol.SettingIqFormat, protocol.StreamFormatInt16), syncReply},
+	{"iq frequency outside window", makeSetSetting(protocol.SettingIqFrequency, mockCenterFrequency+100000), syncReply},
+	{"iq decimation", makeSetSetting(protocol.SettingIqDecimation, 2), func(m *conformanceModel) []uint8 {
+		m.iqDecimation = 2
+		return m.sync()
+	}},
+	{"iq decimation too high", makeSetSetting(protocol.SettingIqDecimation, 9), syncReply},
+	{"iq frequency", makeSetSetting(protocol.SettingIqFrequency, mockCenterFrequency+100000), func(m *conformanceModel) []uint8 {
+		m.iqFrequency = mockCenterFrequency + 100000
+		return m.sync()
+	}},
+	{"gain", makeSetSetting(protocol.SettingGain, 5), func(m *conformanceModel) []uint8 {
+		m.gain = 5
+		return m.sync()
+	}},
+	{"device frequency", makeSetSetting(protocol.SettingDeviceFrequency, mockCenterFrequency+1000000), func(m *conformanceModel) []uint8 {
+		m.deviceFrequency = mockCenterFrequency + 1000000
+		// IQ channel still fits, FFT channel (decimation 0) is moved to the device frequency
+		m.fftFrequency = m.deviceFrequency
+		return m.sync()
+	}},
+	{"device frequency out of range", makeSetSetting(protocol.SettingDeviceFrequency, 1000), deviceErrorReply("Error setting Device Frequency: frequency 1000 is outside the device range (24000000 - 1800000000)")},
+	{"device antenna", makeSetSetting(protocol.SettingDeviceAntenna, 1), syncReply},
+	{"device antenna invalid", makeSetSetting(protocol.SettingDeviceAntenna, 2), deviceErrorReply("Error setting Device Antenna: frontend Mock has no antenna 2")},
+	{"device agc", makeSetSetting(protocol.SettingDeviceAGC, 1), syncReply},
+	{"device bias-t", makeSetSetting(protocol.SettingDeviceBiasT, 1), syncReply},
+	{"fft format", makeSetSetting(protocol.SettingFFTFormat, protocol.StreamFormatUint8), noReply},
+	{"fft decimation", makeSetSetting(protocol.SettingFFTDecimation, 1), func(m *conformanceModel) []uint8 {
+		m.fftDecimation = 1
+		return m.sync()
+	}},
+	{"fft frequency", makeSetSetting(protocol.SettingFFTFrequency, mockCenterFrequency+1500000), func(m *conformanceModel) []uint8 {
+		m.fftFrequency = mockCenterFrequency + 1500000
+		return m.sync()
+	}},
+	{"fft db offset", makeSetSetting(protocol.SettingFFTDbOffset, 10), noReply},
+	{"fft db range", makeSetSetting(protocol.SettingFFTDbRange, 100), syncReply},
+	{"fft display pixels", makeSetSetting(protocol.SettingFFTDisplayPixels, 1024), noReply},
+	{"fft display pixels invalid", makeSetSetting(protocol.SettingFFTDisplayPixels, 10), syncReply},
+	{"unknown setting", makeSetSetting(12345, 1), noReply},
+	{"streaming mode", makeSetSetting(protocol.SettingStreamingMode, protocol.StreamModeFFTIQ), noReply},
+	{"streaming enabled", makeSetSetting(protocol.SettingStreamingEnabled, 1), syncReply},
+	{"iq frequency while streaming", makeSetSetting(protocol.SettingIqFrequency, mockCenterFrequency+200000), func(m *conformanceModel) []uint8 {
+		m.iqFrequency = mockCenterFrequency + 200000
+		return m.sync()
+	}},
+	{"fft display pixels while streaming", makeSetSetting(protocol.SettingFFTDisplayPixels, 2048), syncReply},
+	{"streaming disabled", makeSetSetting(protocol.SettingStreamingEnabled, 0), syncReply},
+}
+
+func runConformanceSteps(t *testing.T, chunkSize int, coalesced bool) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+
+	if coalesced {
+		var commands = make([]uint8, 0)
+		var expected = make([]uint8, 0)
+		for _, step := range conformanceSteps {
+			commands = append(commands, step.command...)
+			expected = append(expected, step.apply(model)...)
+		}
+		cc.send(commands, chunkSize)
+		cc.expect(expected)
+		return
+	}
+
+	for _, step := range conformanceSteps {
+		cc.send(step.command, chunkSize)
+		var expected = step.apply(model)
+		if len(expected) > 0 {
+			t.Logf("step %s", step.name)
+			cc.expect(expected)
+		}
+	}
+}
+
+func TestConformanceSettings(t *testing.T) {
+	runConformanceSteps(t, math.MaxInt32, false)
+}
+
+func TestConformanceSplitReads(t *testing.T) {
+	for _, chunkSize := range []int{1, 3, 5, 8, 13} {
+		runConformanceSteps(t, chunkSize, false)
+	}
+}
+
+func TestConformanceCoalescedReads(t *testing.T) {
+	runConformanceSteps(t, math.MaxInt32, true)
+	runConformanceSteps(t, 7, true)
+}
+
+func TestConformanceControlModeChange(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+	cc.send(makeHello("Conformance"), math.MaxInt32)
+	cc.expect(model.hello())
+
+	// The client loses control and is told so. SetControlMode blocks until the sync is read from the pipe.
+	go serverState.SetControlMode(StateModels.ControlModeAdmin, 0)
+	model.canControl = 0
+	cc.expect(model.sync())
+
+	go serverState.SetControlMode(StateModels.ControlModeFirstCome, 0)
+	model.canControl = 1
+	cc.expect(model.sync())
+}
+
+func TestConformancePing(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var before = time.Now().UnixNano()
+	cc.send(makePing(before), math.MaxInt32)
+
+	var expectedHeader = makeMessage(protocol.MsgTypePong, protocol.StreamTypeStatus, 0, make([]uint8, 8))[:20]
+	cc.expect(expectedHeader)
+
+	var timestamp = int64(binary.LittleEndian.Uint64(cc.read(8)))
+	if timestamp < before || timestamp > time.Now().UnixNano() {
+		t.Errorf("pong timestamp %d is not the server time", timestamp)
+	}
+}
+
+func TestConformanceReadSetting(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	serverState.SetDeviceAntenna(1)
+	serverState.SetDeviceBiasT(true)
+
+	cc.send(makeGetSetting(protocol.SettingDeviceAntenna), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, 0, append(uint32sToBytes(protocol.SettingDeviceAntenna, 1), []uint8("RX1\nRX2")...)))
+
+	cc.send(makeGetSetting(protocol.SettingDeviceBiasT), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, 1, uint32sToBytes(protocol.SettingDeviceBiasT, 1)))
+
+	if cc.frontend.antenna != "RX2" || !cc.frontend.biasT {
+		t.Errorf("expected antenna RX2 with Bias-T got %s (Bias-T %t)", cc.frontend.antenna, cc.frontend.biasT)
+	}
+}
+
+func TestConformanceDeviceChannel(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+	cc.send(makeHello("Conformance"), math.MaxInt32)
+	cc.expect(model.hello())
+
+	// A single channel is reported without a list
+	cc.send(makeGetSetting(protocol.SettingDeviceChannel), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, model.nextSequence(), uint32sToBytes(protocol.SettingDeviceChannel, 0)))
+
+	cc.frontend.Lock()
+	cc.frontend.channelCount = 2
+	cc.frontend.Unlock()
+	serverState.SetDeviceAntenna(1)
+
+	// SetDeviceChannel syncs every client, then the sync sent for every global setting
+	cc.send(makeSetSetting(protocol.SettingDeviceChannel, 1), math.MaxInt32)
+	cc.expect(model.sync())
+	cc.expect(model.sync())
+
+	cc.send(makeGetSetting(protocol.SettingDeviceChannel), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, model.nextSequence(), append(uint32sToBytes(protocol.SettingDeviceChannel, 1), []uint8("0\n1")...)))
+
+	// The new channel reset the antenna
+	cc.send(makeGetSetting(protocol.SettingDeviceAntenna), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, model.nextSequence(), append(uint32sToBytes(protocol.SettingDeviceAntenna, 0), []uint8("RX1\nRX2")...)))
+}
+
+func TestConformanceDeviceSampleRate(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+	cc.send(makeHello("Conformance"), math.MaxInt32)
+	cc.expect(model.hello())
+
+	cc.send(makeSetSetting(protocol.SettingDeviceSampleRate, mockSampleRate/2), math.MaxInt32)
+	cc.expect(expectedDeviceInfo(model.nextSequence(), mockSampleRate/2))
+	cc.expect(model.sync())
+
+	if cc.frontend.sampleRate != mockSampleRate/2 {
+		t.Errorf("expected sample rate %d got %d", mockSampleRate/2, cc.frontend.sampleRate)
+	}
+
+	// Not in the available sample rates
+	cc.send(makeSetSetting(protocol.SettingDeviceSampleRate, 1000000), math.MaxInt32)
+	cc.expect(deviceErrorReply("Error setting Device Sample Rate: frontend Mock does not support the sample rate 1000000")(model))
+
+	cc.send(makeGetSetting(protocol.SettingDeviceSampleRate), math.MaxInt32)
+	cc.expect(makeMessage(protocol.MsgTypeReadSetting, protocol.StreamTypeStatus, model.nextSequence(), append(uint32sToBytes(protocol.SettingDeviceSampleRate, mockSampleRate/2), []uint8("2500000\n1250000")...)))
+}
+
+func TestConformanceIQ(t *testing.T) {
+	var samples = []complex64{complex(0, 0.5), complex(-0.25, 0.125), complex(0.75, -1), complex(-0.5, 1.0/64)}
+
+	var cases = []struct {
+		format      uint32
+		messageType uint32
+		body        func() []uint8
+	}{
+		{protocol.StreamFormatFloat, protocol.MsgTypeFloatIQ, func() []uint8 {
+			var data = make([]uint8, 0)
+			for _, v := range samples {
+				data = append(data, uint32sToBytes(math.Float32bits(real(v)), math.Float32bits(imag(v)))...)
+			}
+			return data
+		}},
+		{protocol.StreamFormatInt16, protocol.MsgTypeInt16IQ, func() []uint8 {
+			var data = make([]uint8, 0)
+			for _, v := range samples {
+				var iq = make([]uint8, 4)
+				binary.LittleEndian.PutUint16(iq[0:], uint16(int16(real(v)*32768)))
+				binary.LittleEndian.PutUint16(iq[2:], uint16(int16(imag(v)*32768)))
+				data = append(data, iq...)
+			}
+			return data
+		}},
+		{protocol.StreamFormatUint8, protocol.MsgTypeUint8IQ, func() []uint8 {
+			var data = make([]uint8, 0)
+			for _, v := range samples {
+				// 127.5 is zero, rounded to the nearest value
+				data = append(data, uint8(math.Round(float64(real(v))*127.5+127.5)), uint8(math.Round(float64(imag(v))*127.5+127.5)))
+			}
+			return data
+		}},
+	}
+
+	for _, c := range cases {
+		var cc = startConformanceClient(t)
+		var model = createConformanceModel()
+
+		cc.send(makeHello("Conformance IQ"), math.MaxInt32)
+		cc.expect(model.hello())
+		cc.send(makeSetSetting(protocol.SettingIqFormat, c.format), math.MaxInt32)
+		cc.expect(model.sync())
+		cc.send(makeSetSetting(protocol.SettingStreamingEnabled, 1), math.MaxInt32)
+		cc.expect(model.sync())
+		cc.waitSentPackets(uint64(model.sequence))
+
+		cc.frontend.pushSamples(samples)
+		cc.expect(makeMessage(c.messageType, protocol.StreamModeIQOnly, model.nextSequence(), c.body()))
+
+		cc.close()
+	}
+}
+
+func TestConformanceIQSegmentation(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+
+	cc.send(makeHello("Conformance Segmentation"), math.MaxInt32)
+	cc.expect(model.hello())
+	cc.send(makeSetSetting(protocol.SettingIqFormat, protocol.StreamFormatFloat), math.MaxInt32)
+	cc.expect(model.sync())
+	cc.send(makeSetSetting(protocol.SettingStreamingEnabled, 1), math.MaxInt32)
+	cc.expect(model.sync())
+
+	// 1.5 times the maximum body size in complex64 samples
+	var samples = make([]complex64, protocol.MaxMessageBodySize*3/2/8)
+	for i := range samples {
+		samples[i] = complex(float32(i%100)/100, 0)
+	}
+	var body = tools.Complex64ArrayToBytes(samples)
+
+	cc.waitSentPackets(uint64(model.sequence))
+	cc.frontend.pushSamples(samples)
+	cc.expect(makeMessage(protocol.MsgTypeFloatIQ, protocol.StreamModeIQOnly, model.nextSequence(), body[:protocol.MaxMessageBodySize]))
+	cc.expect(makeMessage(protocol.MsgTypeFloatIQ, protocol.StreamModeIQOnly, model.nextSequence(), body[protocol.MaxMessageBodySize:]))
+}
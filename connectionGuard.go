@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errTooManyClients       = errors.New("too many clients")
+	errTooManyClientsFromIP = errors.New("too many clients from the same address")
+	errNotAllowed           = errors.New("address not allowed")
+	errBanned               = errors.New("address temporarily banned")
+)
+
+const defaultBanTime = 10 * time.Minute
+
+// connectionGuard enforces a LimitsConfig. There is one for the whole server and one for each listener.
+type connectionGuard struct {
+	config *LimitsConfig
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+
+	mtx          sync.Mutex
+	clients      int
+	clientsPerIP map[string]int
+	errorCount   map[string]int
+	lastError    map[string]time.Time
+	bannedUntil  map[string]time.Time
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets = make([]*net.IPNet, 0)
+	for _, v := range cidrs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %s", v, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func matchesAny(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+func createConnectionGuard(config *LimitsConfig) (*connectionGuard, error) {
+	allow, err := parseCIDRs(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := parseCIDRs(config.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectionGuard{
+		config:       config,
+		allow:        allow,
+		deny:         deny,
+		clientsPerIP: make(map[string]int),
+		errorCount:   make(map[string]int),
+		lastError:    make(map[string]time.Time),
+		bannedUntil:  make(map[string]time.Time),
+	}, nil
+}
+
+func (g *connectionGuard) banTime() time.Duration {
+	if g.config.BanSeconds > 0 {
+		return time.Duration(g.config.BanSeconds) * time.Second
+	}
+
+	return defaultBanTime
+}
+
+// admit reserves a slot for a client from the specified IP. release must be called if it returns nil.
+func (g *connectionGuard) admit(ip net.IP) error {
+	if matchesAny(ip, g.deny) || (len(g.allow) > 0 && !matchesAny(ip, g.allow)) {
+		return errNotAllowed
+	}
+
+	var key = ip.String()
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if until, ok := g.bannedUntil[key]; ok {
+		if time.Now().Before(until) {
+			return errBanned
+		}
+		delete(g.bannedUntil, key)
+	}
+
+	if g.config.MaxClients > 0 && g.clients >= g.config.MaxClients {
+		return errTooManyClients
+	}
+
+	if g.config.MaxClientsPerIP > 0 && g.clientsPerIP[key] >= g.config.MaxClientsPerIP {
+		return errTooManyClientsFromIP
+	}
+
+	g.clients++
+	g.clientsPerIP[key]++
+
+	return nil
+}
+
+func (g *connectionGuard) release(ip net.IP) {
+	var key = ip.String()
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.clients--
+	g.clientsPerIP[key]--
+	if g.clientsPerIP[key] <= 0 {
+		delete(g.clientsPerIP, key)
+	}
+}
+
+// reportProtocolErrors counts protocol errors from an IP and bans it when BanAfterErrors is reached.
+// Errors older than the ban time are forgotten.
+func (g *connectionGuard) reportProtocolErrors(ip net.IP, count int) {
+	if g.config.BanAfterErrors <= 0 || count <= 0 {
+		return
+	}
+
+	var key = ip.String()
+	var now = time.Now()
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	// Forget stale entries so a scan from many addresses does not grow the maps forever
+	for k, v := range g.lastError {
+		if now.Sub(v) > g.banTime() {
+			delete(g.errorCount, k)
+			delete(g.lastError, k)
+		}
+	}
+	for k, v := range g.bannedUntil {
+		if now.After(v) {
+			delete(g.bannedUntil, k)
+		}
+	}
+
+	g.errorCount[key] += count
+	g.lastError[key] = now
+
+	if g.errorCount[key] >= g.config.BanAfterErrors {
+		tcpSlog.Warn("Banning %s for %s after %d protocol errors", key, g.banTime(), g.errorCount[key])
+		g.bannedUntil[key] = now.Add(g.banTime())
+		delete(g.errorCount, key)
+		delete(g.lastError, key)
+	}
+}
+
+func (g *connectionGuard) rejectMessage(reason error) string {
+	if g.config.RejectMessage != "" {
+		return g.config.RejectMessage
+	}
+
+	return fmt.Sprintf("Connection rejected: %s", reason)
+}
+
+// isPoliteRejection returns true for rejections where the client should be told why. Denied and banned addresses
+// are just disconnected.
+func isPoliteRejection(reason error) bool {
+	return reason == errTooManyClients || reason == errTooManyClientsFromIP
+}
@@ -3,31 +3,31 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/racerxdl/radioserver/StateModels"
 	"github.com/racerxdl/radioserver/protocol"
 	"github.com/racerxdl/radioserver/tools"
+	"time"
 )
 
 func parseMessage(state *StateModels.ClientState, buffer []uint8) {
-	state.ReceivedBytes += uint64(len(buffer))
+	state.AddReceivedBytes(len(buffer))
 
 	var consumed uint32
+	var err error
 
-	for len(buffer) > 0 && tcpServerStatus && state.Running {
+	for len(buffer) > 0 && tcpServerStatus && state.IsRunning() {
 		if state.CurrentState == protocol.ParserAcquiringHeader {
 			for state.CurrentState == protocol.ParserAcquiringHeader && len(buffer) > 0 {
-				consumed = parseHeader(state, buffer)
+				consumed, err = parseHeader(state, buffer)
 				buffer = buffer[consumed:]
-			}
-
-			if state.CurrentState == protocol.ParserReadingData {
-
-				if state.Cmd.BodySize > protocol.MaxMessageBodySize {
-					state.Error("Client sent an BodySize of %d which is higher than max %d", state.Cmd.BodySize, protocol.MaxMessageBodySize)
-					state.Running = false
+				if err != nil {
+					protocolFailure(state, err)
 					return
 				}
+			}
 
+			if state.CurrentState == protocol.ParserReadingData {
 				state.CmdBody = make([]uint8, state.Cmd.BodySize)
 			}
 		}
@@ -38,12 +38,23 @@ func parseMessage(state *StateModels.ClientState, buffer []uint8) {
 
 			if state.CurrentState == protocol.ParserAcquiringHeader {
 				state.CmdReceived++
-				runCommand(state)
+				err = runCommand(state)
+				if err != nil {
+					protocolFailure(state, err)
+					return
+				}
 			}
 		}
 	}
 }
 
+// protocolFailure disconnects a client that sent malformed data, telling it why
+func protocolFailure(state *StateModels.ClientState, err error) {
+	state.ProtocolError("Protocol error: %s", err)
+	state.SendServerMessage(protocol.ServerMessageDisconnected, err.Error())
+	state.Disconnect(fmt.Sprintf("protocol error: %s", err))
+}
+
 func parseBody(state *StateModels.ClientState, buffer []uint8) uint32 {
 	consumed := uint32(0)
 
@@ -66,7 +77,7 @@ func parseBody(state *StateModels.ClientState, buffer []uint8) uint32 {
 	return consumed
 }
 
-func parseHeader(state *StateModels.ClientState, buffer []uint8) uint32 {
+func parseHeader(state *StateModels.ClientState, buffer []uint8) (uint32, error) {
 	consumed := uint32(0)
 
 	for len(buffer) > 0 {
@@ -83,30 +94,47 @@ func parseHeader(state *StateModels.ClientState, buffer []uint8) uint32 {
 			buf := bytes.NewReader(state.HeaderBuffer)
 			err := binary.Read(buf, binary.LittleEndian, &state.Cmd)
 			if err != nil {
-				panic(err)
+				return consumed, err
+			}
+
+			if state.Cmd.BodySize > protocol.MaxMessageBodySize {
+				return consumed, fmt.Errorf("client sent a BodySize of %d which is higher than max %d", state.Cmd.BodySize, protocol.MaxMessageBodySize)
+			}
+
+			err = protocol.ValidateCommandHeader(state.Cmd)
+			if err != nil {
+				return consumed, err
 			}
 
 			if state.Cmd.BodySize > 0 {
 				state.CurrentState = protocol.ParserReadingData
 			}
 
-			return consumed
+			return consumed, nil
 		}
 	}
 
-	return consumed
+	return consumed, nil
 }
 
-func runCommand(state *StateModels.ClientState) {
+func runCommand(state *StateModels.ClientState) error {
 	var cmdType = state.Cmd.CommandType
+	state.LastCommandTime = time.Now()
+
+	switch cmdType {
+	case protocol.CmdHello:
+		return RunCmdHello(state)
+	case protocol.CmdGetSetting:
+		return RunCmdGetSetting(state)
+	case protocol.CmdSetSetting:
+		return RunCmdSetSetting(state)
+	case protocol.CmdPing:
+		return RunCmdPing(state)
+	}
 
-	if cmdType == protocol.CmdHello {
-		RunCmdHello(state)
-	} else if cmdType == protocol.CmdGetSetting {
-		RunCmdGetSetting(state)
-	} else if cmdType == protocol.CmdSetSetting {
-		RunCmdSetSetting(state)
-	} else if cmdType == protocol.CmdPing {
-		RunCmdPing(state)
+	return &protocol.CommandError{
+		CommandType: cmdType,
+		BodySize:    state.Cmd.BodySize,
+		Reason:      "unknown command",
 	}
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// setupTestServer replaces the global server state with one using a mockFrontend
+func setupTestServer() *mockFrontend {
+	var frontend = createMockFrontend()
+
+	serverState = StateModels.CreateServerState()
+	serverState.Frontend = frontend
+	frontend.SetSamplesAvailableCallback(serverState.PushSamples)
+	serverState.OpenFrontend(context.Background(), nil, 0)
+	serverState.SetControlMode(StateModels.ControlModeFirstCome, 0)
+	serverStates = []*StateModels.ServerState{serverState}
+	tcpServerStatus = true
+
+	return frontend
+}
+
+// createDiscardClient creates a client whose output is thrown away
+func createDiscardClient() (*StateModels.ClientState, func()) {
+	server, client := net.Pipe()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, client)
+	}()
+
+	var state = createClientState(server, serverState)
+	serverState.PushClient(state)
+
+	return state, func() {
+		state.FullStop()
+		serverState.RemoveClient(state)
+		_ = server.Close()
+		_ = client.Close()
+	}
+}
+
+func makeCommand(commandType uint32, body []uint8) []uint8 {
+	var header = protocol.CommandHeader{
+		CommandType: commandType,
+		BodySize:    uint32(len(body)),
+	}
+
+	return append(tools.StructToBytes(header), body...)
+}
+
+func makeSetSetting(setting uint32, args ...uint32) []uint8 {
+	var body = make([]uint8, 4*(1+len(args)))
+	binary.LittleEndian.PutUint32(body, setting)
+	for i, v := range args {
+		binary.LittleEndian.PutUint32(body[4*(i+1):], v)
+	}
+
+	return makeCommand(protocol.CmdSetSetting, body)
+}
+
+func makeGetSetting(setting uint32) []uint8 {
+	return makeCommand(protocol.CmdGetSetting, tools.StructToBytes(setting))
+}
+
+func makeHello(name string) []uint8 {
+	var body = tools.StructToBytes(ServerVersion.ToUint32())
+	return makeCommand(protocol.CmdHello, append(body, []uint8(name)...))
+}
+
+func makePing(timestamp int64) []uint8 {
+	return makeCommand(protocol.CmdPing, tools.StructToBytes(timestamp))
+}
+
+type parserResult struct {
+	cmdReceived    uint64
+	protocolErrors int
+	running        bool
+}
+
+func runParser(data []uint8, chunkSize int) parserResult {
+	var state, closeClient = createDiscardClient()
+	defer closeClient()
+
+	for len(data) > 0 && state.IsRunning() {
+		var n = chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		parseMessage(state, data[:n])
+		data = data[n:]
+	}
+
+	return parserResult{
+		cmdReceived:    state.CmdReceived,
+		protocolErrors: state.ProtocolErrors,
+		running:        state.IsRunning(),
+	}
+}
+
+func TestParseMessageMalformed(t *testing.T) {
+	setupTestServer()
+
+	var cases = map[string][]uint8{
+		"empty set setting":   makeCommand(protocol.CmdSetSetting, []uint8{}),
+		"set setting no args": makeCommand(protocol.CmdSetSetting, []uint8{2, 0, 0, 0}),
+		"short hello":         makeCommand(protocol.CmdHello, []uint8{1, 2}),
+		"short ping":          makeCommand(protocol.CmdPing, []uint8{1, 2, 3, 4}),
+		"unknown command":     makeCommand(1234, []uint8{1, 2, 3, 4}),
+		"huge body":           {2, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF},
+		"http request":        []uint8("GET / HTTP/1.1\r\nHost: radioserver\r\n\r\n"),
+	}
+
+	for name, data := range cases {
+		var result = runParser(data, len(data))
+		if result.running || result.protocolErrors != 1 {
+			t.Errorf("%s: expected a disconnect with one protocol error, got %+v", name, result)
+		}
+	}
+}
+
+func TestParseMessageUnknownSettings(t *testing.T) {
+	setupTestServer()
+
+	// Valid commands with settings the server doesn't know don't count towards a ban
+	var data = makeSetSetting(protocol.SettingDigitalGain, 10)
+	data = append(data, makeSetSetting(9999, 1)...)
+	data = append(data, makeGetSetting(9999)...)
+
+	var result = runParser(data, len(data))
+	if result.cmdReceived != 3 || result.protocolErrors != 0 || !result.running {
+		t.Errorf("expected no protocol errors got %+v", result)
+	}
+}
+
+func TestParseMessageSplitReads(t *testing.T) {
+	setupTestServer()
+
+	var data = makeHello("Split Test")
+	data = append(data, makeSetSetting(protocol.SettingIqFormat, protocol.StreamFormatInt16)...)
+	data = append(data, makePing(1234)...)
+
+	for _, chunkSize := range []int{1, 3, 7, 8, 9, len(data)} {
+		var result = runParser(data, chunkSize)
+		if result.cmdReceived != 3 || result.protocolErrors != 0 || !result.running {
+			t.Errorf("chunk size %d: got %+v", chunkSize, result)
+		}
+	}
+}
+
+// FuzzParseMessage checks that the parser never panics and that the result does not depend on how the input is split
+func FuzzParseMessage(f *testing.F) {
+	setupTestServer()
+
+	f.Add(makeHello("Fuzz"))
+	f.Add(append(makeHello("Fuzz"), makePing(1)...))
+	f.Add(makeSetSetting(protocol.SettingIqFrequency, 106300000))
+	f.Add(makeSetSetting(protocol.SettingFFTDisplayPixels))
+	f.Add(makeCommand(protocol.CmdSetSetting, []uint8{}))
+	f.Add([]uint8{2, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []uint8) {
+		var whole = runParser(data, len(data)+1)
+		var split = runParser(data, 3)
+
+		if whole != split {
+			t.Errorf("parser results differ: whole %+v split %+v", whole, split)
+		}
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	setupTestServer()
+
+	if timeouts := DefaultServerConfig().Timeouts.ClientTimeouts(); timeouts != (StateModels.ClientTimeouts{Write: 10 * time.Second}) {
+		t.Errorf("expected only the write timeout by default got %+v", timeouts)
+	}
+
+	var state, closeClient = createDiscardClient()
+	defer closeClient()
+	state.Timeouts.Idle = time.Minute
+
+	// Any command resets the idle timer
+	state.LastCommandTime = time.Now().Add(-2 * time.Minute)
+	parseMessage(state, makePing(1234))
+	if !state.CheckTimeouts() {
+		t.Fatalf("expected the ping to reset the idle timer")
+	}
+
+	state.LastCommandTime = time.Now().Add(-2 * time.Minute)
+	if state.CheckTimeouts() || state.GetDisconnectReason() == "" {
+		t.Errorf("expected an idle disconnect")
+	}
+}
@@ -1,28 +1,65 @@
+//go:build airspy
+// +build airspy
+
 package frontends
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/racerxdl/radioserver/SLog"
 	"github.com/racerxdl/radioserver/protocol"
 	"github.com/racerxdl/spy2go/airspy"
 	"github.com/racerxdl/spy2go/spytypes"
-	"math"
+	"sync"
 )
 
-const airspyMaximumFrequency = 1.768e6
+const airspyMaximumFrequency = 1.8e9
 const airspyMinimumFrequency = 24e6
 
 var airspyLog = SLog.Scope("Airspy Frontend")
 
+var errAirspyClosed = errors.New("airspy is not open")
+
+// libairspy is initialized once per process, while any Airspy is open
+var airspyLibMtx sync.Mutex
+var airspyLibUsers int
+
+func initializeAirspyLib() {
+	airspyLibMtx.Lock()
+	defer airspyLibMtx.Unlock()
+
+	if airspyLibUsers == 0 {
+		airspy.Initialize()
+	}
+	airspyLibUsers++
+}
+
+func deInitializeAirspyLib() {
+	airspyLibMtx.Lock()
+	defer airspyLibMtx.Unlock()
+
+	airspyLibUsers--
+	if airspyLibUsers == 0 {
+		airspy.DeInitialize()
+	}
+}
+
+func init() {
+	RegisterFrontend("airspy", func(options FrontendOptions) (Frontend, error) {
+		return CreateAirspyFrontend(0), nil
+	})
+}
+
 type AirspyFrontend struct {
 	device *airspy.Device
 	cb     SamplesCallback
 
-	deviceSerial       uint64
-	maxSampleRate      uint32
-	maxDecimationStage uint32
-	currentGain        uint8
-	running            bool
+	deviceSerial  uint64
+	maxSampleRate uint32
+	currentGain   uint8
+	running       bool
+	closed        bool
 }
 
 type internalCallback struct {
@@ -33,48 +70,61 @@ func (ic *internalCallback) OnData(dType int, data interface{}) {
 	ic.parent(dType, data)
 }
 
+// CreateAirspyFrontend creates a frontend for the Airspy with the specified serial (0 for the first one). The device
+// is opened by Init.
 func CreateAirspyFrontend(serial uint64) Frontend {
-	airspy.Initialize()
-	var f = &AirspyFrontend{
-		device:        airspy.MakeAirspyDevice(serial),
-		deviceSerial:  0,
+	return &AirspyFrontend{
+		deviceSerial:  serial,
 		maxSampleRate: 0,
 		currentGain:   0,
 		running:       false,
+		closed:        true,
 	}
+}
+
+// open initializes libairspy and opens the device. After the first time, the device is opened by its serial.
+func (f *AirspyFrontend) open() (err error) {
+	var device *airspy.Device
 
-	f.device.SetSampleType(spytypes.SamplesComplex64)
+	// spy2go panics when the device can't be opened
+	defer func() {
+		if r := recover(); r != nil {
+			if device != nil {
+				device.Close()
+			}
+			deInitializeAirspyLib()
+			err = fmt.Errorf("error opening Airspy %s: %v", f.GetDeviceSerial(), r)
+		}
+	}()
+
+	initializeAirspyLib()
+	device = airspy.MakeAirspyDevice(f.deviceSerial)
+	device.SetSampleType(spytypes.SamplesComplex64)
 
 	if f.deviceSerial == 0 {
 		// Fetch device serial
-		f.deviceSerial = f.device.GetSerial()
+		f.deviceSerial = device.GetSerial()
 	}
 
-	for _, v := range f.device.GetAvailableSampleRates() {
-		if v > f.maxSampleRate {
-			f.maxSampleRate = v
-		}
+	var ic = &internalCallback{
+		parent: f.internalCb,
 	}
 
-	var maxDecimationStage = uint32(0)
-	var calcSR = f.maxSampleRate
+	device.SetCallback(ic)
 
-	for calcSR >= minimumSampleRate {
-		maxDecimationStage += 1
-		var decim = uint32(math.Pow(2, float64(maxDecimationStage)))
-		calcSR = f.maxSampleRate / decim
+	f.maxSampleRate = 0
+	for _, v := range device.GetAvailableSampleRates() {
+		if v > f.maxSampleRate {
+			f.maxSampleRate = v
+		}
 	}
 
-	f.maxDecimationStage = maxDecimationStage
+	device.SetSampleRate(f.maxSampleRate)
 
-	var ic = &internalCallback{
-		parent: f.internalCb,
-	}
-
-	f.device.SetCallback(ic)
-	f.device.SetSampleRate(f.maxSampleRate)
+	f.device = device
+	f.closed = false
 
-	return f
+	return nil
 }
 
 func (f *AirspyFrontend) GetUintDeviceSerial() uint32 {
@@ -90,7 +140,7 @@ func (f *AirspyFrontend) MaximumFrequency() uint32 {
 }
 
 func (f *AirspyFrontend) GetMaximumBandwidth() uint32 {
-	return uint32(float32(f.maxSampleRate) * 0.8)
+	return uint32(float32(f.GetSampleRate()) * 0.8)
 }
 
 func (f *AirspyFrontend) MaximumGainIndex() uint32 {
@@ -98,7 +148,7 @@ func (f *AirspyFrontend) MaximumGainIndex() uint32 {
 }
 
 func (f *AirspyFrontend) MaximumDecimationStages() uint32 {
-	return f.maxDecimationStage
+	return decimationStages(f.GetSampleRate())
 }
 
 func (f *AirspyFrontend) GetDeviceType() uint32 {
@@ -121,67 +171,131 @@ func (f *AirspyFrontend) GetDeviceSerial() string {
 func (f *AirspyFrontend) GetMaximumSampleRate() uint32 {
 	return f.maxSampleRate
 }
-func (f *AirspyFrontend) SetSampleRate(sampleRate uint32) uint32 {
+func (f *AirspyFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	if f.closed {
+		return 0, errAirspyClosed
+	}
+
 	f.device.SetSampleRate(sampleRate)
-	return f.device.GetSampleRate()
+	return f.device.GetSampleRate(), nil
 }
-func (f *AirspyFrontend) SetCenterFrequency(centerFrequency uint32) uint32 {
+func (f *AirspyFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	if f.closed {
+		return f.GetCenterFrequency(), errAirspyClosed
+	}
+
 	f.device.SetCenterFrequency(centerFrequency)
-	return f.device.GetCenterFrequency()
+	return f.device.GetCenterFrequency(), nil
 }
 func (f *AirspyFrontend) GetAvailableSampleRates() []uint32 {
+	if f.device == nil {
+		return []uint32{}
+	}
+
 	return f.device.GetAvailableSampleRates()
 }
-func (f *AirspyFrontend) Start() {
+func (f *AirspyFrontend) Start() error {
+	if f.closed {
+		return errAirspyClosed
+	}
+
 	if !f.running {
 		airspyLog.Info("Starting")
 		f.device.Start()
 		f.running = true
 	}
+
+	return nil
 }
-func (f *AirspyFrontend) Stop() {
+func (f *AirspyFrontend) Stop() error {
 	if f.running {
 		airspyLog.Info("Stopping")
 		f.device.Stop()
 		f.running = false
 	}
+
+	return nil
 }
-func (f *AirspyFrontend) SetAntenna(value string) {
-	airspyLog.Warn("Airspy Frontend does not support antenna switch. Ignoring...")
+func (f *AirspyFrontend) SetAntenna(value string) error {
+	return ErrNotSupported
 }
-func (f *AirspyFrontend) SetAGC(agc bool) {
+func (f *AirspyFrontend) SetAGC(agc bool) error {
+	if f.closed {
+		return errAirspyClosed
+	}
+
 	f.device.SetAGC(agc)
+	return nil
 }
-func (f *AirspyFrontend) SetGain(value uint8) {
+func (f *AirspyFrontend) SetGain(value uint8) error {
+	if f.closed {
+		return errAirspyClosed
+	}
+
 	f.device.SetLinearityGain(value)
 	f.currentGain = value
+	return nil
 }
 func (f *AirspyFrontend) GetGain() uint8 {
 	return f.currentGain
 }
-func (f *AirspyFrontend) SetBiasT(value bool) {
+func (f *AirspyFrontend) SetBiasT(value bool) error {
+	if f.closed {
+		return errAirspyClosed
+	}
+
 	f.device.SetBiasT(value)
+	return nil
 }
 func (f *AirspyFrontend) GetCenterFrequency() uint32 {
+	if f.device == nil {
+		return 0
+	}
+
 	return f.device.GetCenterFrequency()
 }
 func (f *AirspyFrontend) GetName() string {
+	if f.device == nil {
+		return fmt.Sprintf("%s %s", protocol.DeviceAirspyOneName, f.GetDeviceSerial())
+	}
+
 	return f.device.GetName()
 }
 func (f *AirspyFrontend) GetShortName() string {
 	return "Airspy"
 }
 func (f *AirspyFrontend) GetSampleRate() uint32 {
+	if f.device == nil {
+		return 0
+	}
+
 	return f.device.GetSampleRate()
 }
 func (f *AirspyFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
 	f.cb = cb
 }
-func (f *AirspyFrontend) Init() bool {
-	return true
+
+// Init opens the device at its maximum sample rate, if it's not open yet or was closed by Destroy
+func (f *AirspyFrontend) Init(ctx context.Context) error {
+	if !f.closed {
+		return nil
+	}
+
+	airspyLog.Info("Opening %s", f.GetDeviceSerial())
+	return f.open()
 }
 
-func (f *AirspyFrontend) Destroy() {
-	airspyLog.Info("De-initializing")
-	airspy.DeInitialize()
+func (f *AirspyFrontend) Destroy() error {
+	if f.closed {
+		return nil
+	}
+
+	airspyLog.Info("Closing %s", f.GetDeviceSerial())
+	_ = f.Stop()
+	f.device.Close()
+	f.device = nil
+	f.closed = true
+	deInitializeAirspyLib()
+
+	return nil
 }
@@ -0,0 +1,173 @@
+package frontends
+
+import (
+	"github.com/racerxdl/radioserver/SLog"
+	"math"
+)
+
+// correctionAlpha is the weight of each sample in the DC and IQ imbalance estimates. The estimates settle after a few
+// times 1 / correctionAlpha samples.
+const correctionAlpha = 1e-4
+
+var correctionLog = SLog.Scope("Correction")
+
+// CorrectionOptions are the corrections applied by CorrectionFrontend
+type CorrectionOptions struct {
+	// PPM is the frequency error of the device oscillator. A device with a positive error receives a signal at f when
+	// tuned to f / (1 + PPM / 1e6).
+	PPM float64
+	// RemoveDC removes the DC offset (the spike at the center of the spectrum)
+	RemoveDC bool
+	// CorrectIQ estimates and corrects the gain and phase imbalance between I and Q (the mirror image of signals)
+	CorrectIQ bool
+}
+
+// Enabled returns true if any correction has to be applied
+func (o CorrectionOptions) Enabled() bool {
+	return o.PPM != 0 || o.RemoveDC || o.CorrectIQ
+}
+
+// CorrectionFrontend wraps another frontend correcting its frequency error, DC offset and IQ imbalance, so every client
+// gets corrected samples
+type CorrectionFrontend struct {
+	Frontend
+	options CorrectionOptions
+	cb      SamplesCallback
+
+	dc complex64
+
+	// Running averages of I², Q² and I*Q, for the IQ imbalance estimation
+	ii float32
+	qq float32
+	iq float32
+}
+
+// CreateCorrectionFrontend wraps frontend with the specified corrections
+func CreateCorrectionFrontend(frontend Frontend, options CorrectionOptions) *CorrectionFrontend {
+	var f = &CorrectionFrontend{
+		Frontend: frontend,
+		options:  options,
+	}
+
+	correctionLog.Info("PPM: %.2f, DC Removal: %t, IQ Correction: %t", options.PPM, options.RemoveDC, options.CorrectIQ)
+
+	frontend.SetSamplesAvailableCallback(f.onSamples)
+
+	return f
+}
+
+// toDevice converts a frequency to the one the device has to be tuned to
+func (f *CorrectionFrontend) toDevice(frequency uint32) uint32 {
+	return uint32(math.Round(float64(frequency) / (1 + f.options.PPM/1e6)))
+}
+
+// fromDevice converts a device frequency to the one that is actually received
+func (f *CorrectionFrontend) fromDevice(frequency uint32) uint32 {
+	var corrected = math.Round(float64(frequency) * (1 + f.options.PPM/1e6))
+	if corrected > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(corrected)
+}
+
+func (f *CorrectionFrontend) onSamples(samples []complex64) {
+	if f.options.RemoveDC || f.options.CorrectIQ {
+		f.correct(samples)
+	}
+
+	if f.cb != nil {
+		f.cb(samples)
+	}
+}
+
+// correct removes the DC offset and the IQ imbalance in place. The imbalance is estimated from the statistics of I and
+// Q, which should have the same power and no correlation: Q is scaled by sqrt(E[I²] / E[Q²]) and the leaked part of I,
+// given by sin(phase error) = E[I*Q] / sqrt(E[I²] * E[Q²]), is removed from it.
+func (f *CorrectionFrontend) correct(samples []complex64) {
+	for i, v := range samples {
+		if f.options.RemoveDC {
+			f.dc += correctionAlpha * (v - f.dc)
+			v -= f.dc
+		}
+
+		if f.options.CorrectIQ {
+			var re, im = real(v), imag(v)
+			f.ii += correctionAlpha * (re*re - f.ii)
+			f.qq += correctionAlpha * (im*im - f.qq)
+			f.iq += correctionAlpha * (re*im - f.iq)
+
+			if f.ii > 0 && f.qq > 0 {
+				var gain = float32(math.Sqrt(float64(f.ii / f.qq)))
+				var sinPhase = f.iq / float32(math.Sqrt(float64(f.ii*f.qq)))
+				var cosPhase = float32(math.Sqrt(math.Max(float64(1-sinPhase*sinPhase), 1e-6)))
+				v = complex(re, (im*gain-re*sinPhase)/cosPhase)
+			}
+		}
+
+		samples[i] = v
+	}
+}
+
+func (f *CorrectionFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+func (f *CorrectionFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	deviceFrequency, err := f.Frontend.SetCenterFrequency(f.toDevice(centerFrequency))
+	return f.fromDevice(deviceFrequency), err
+}
+
+func (f *CorrectionFrontend) GetCenterFrequency() uint32 {
+	return f.fromDevice(f.Frontend.GetCenterFrequency())
+}
+
+func (f *CorrectionFrontend) MinimumFrequency() uint32 {
+	return f.fromDevice(f.Frontend.MinimumFrequency())
+}
+
+func (f *CorrectionFrontend) MaximumFrequency() uint32 {
+	return f.fromDevice(f.Frontend.MaximumFrequency())
+}
+
+// The optional interfaces are forwarded, since the embedded frontend is hidden from type assertions
+
+func (f *CorrectionFrontend) GetAntennas() []string {
+	if selector, ok := f.Frontend.(AntennaSelector); ok {
+		return selector.GetAntennas()
+	}
+
+	return []string{}
+}
+
+func (f *CorrectionFrontend) GetAntenna() string {
+	if selector, ok := f.Frontend.(AntennaSelector); ok {
+		return selector.GetAntenna()
+	}
+
+	return ""
+}
+
+func (f *CorrectionFrontend) GetChannelCount() uint32 {
+	if selector, ok := f.Frontend.(ChannelSelector); ok {
+		return selector.GetChannelCount()
+	}
+
+	return 1
+}
+
+func (f *CorrectionFrontend) GetChannel() uint32 {
+	if selector, ok := f.Frontend.(ChannelSelector); ok {
+		return selector.GetChannel()
+	}
+
+	return 0
+}
+
+func (f *CorrectionFrontend) SetChannel(channel uint32) error {
+	if selector, ok := f.Frontend.(ChannelSelector); ok {
+		return selector.SetChannel(channel)
+	}
+
+	return ErrNotSupported
+}
@@ -0,0 +1,130 @@
+package frontends
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// testFrontend only keeps the values set, samples are delivered through push
+type testFrontend struct {
+	centerFrequency uint32
+	cb              SamplesCallback
+}
+
+func (f *testFrontend) push(samples []complex64) {
+	if f.cb != nil {
+		f.cb(samples)
+	}
+}
+
+func (f *testFrontend) GetDeviceType() uint32        { return 0 }
+func (f *testFrontend) GetDeviceSerial() string      { return "" }
+func (f *testFrontend) GetUintDeviceSerial() uint32  { return 0 }
+func (f *testFrontend) GetMaximumSampleRate() uint32 { return 2500000 }
+func (f *testFrontend) GetMaximumBandwidth() uint32  { return 2000000 }
+func (f *testFrontend) SetSampleRate(uint32) (uint32, error) {
+	return 2500000, nil
+}
+func (f *testFrontend) GetAvailableSampleRates() []uint32 { return []uint32{2500000} }
+func (f *testFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	f.centerFrequency = centerFrequency
+	return f.centerFrequency, nil
+}
+func (f *testFrontend) Start() error                    { return nil }
+func (f *testFrontend) Stop() error                     { return nil }
+func (f *testFrontend) SetAntenna(string) error         { return nil }
+func (f *testFrontend) SetAGC(bool) error               { return nil }
+func (f *testFrontend) SetGain(uint8) error             { return nil }
+func (f *testFrontend) SetBiasT(bool) error             { return nil }
+func (f *testFrontend) GetCenterFrequency() uint32      { return f.centerFrequency }
+func (f *testFrontend) GetName() string                 { return "Test Frontend" }
+func (f *testFrontend) GetShortName() string            { return "Test" }
+func (f *testFrontend) GetSampleRate() uint32           { return 2500000 }
+func (f *testFrontend) GetGain() uint8                  { return 0 }
+func (f *testFrontend) Init(context.Context) error      { return nil }
+func (f *testFrontend) Destroy() error                  { return nil }
+func (f *testFrontend) MinimumFrequency() uint32        { return 24e6 }
+func (f *testFrontend) MaximumFrequency() uint32        { return 1.8e9 }
+func (f *testFrontend) MaximumGainIndex() uint32        { return 0 }
+func (f *testFrontend) MaximumDecimationStages() uint32 { return 8 }
+func (f *testFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+// tone returns a complex tone with the specified gain and phase error in Q, plus a DC offset
+func tone(count int, frequency, gain, phase float64, dc complex64) []complex64 {
+	var samples = make([]complex64, count)
+	for i := range samples {
+		var w = 2 * math.Pi * frequency * float64(i)
+		samples[i] = complex(float32(math.Cos(w)), float32(gain*math.Sin(w+phase))) + dc
+	}
+
+	return samples
+}
+
+// toneLevels returns the level of the tone and of its mirror image in the last samples
+func toneLevels(samples []complex64, frequency float64, count int) (float64, float64) {
+	var start = len(samples) - count
+	var signal, image complex128
+	for i := start; i < len(samples); i++ {
+		var w = 2 * math.Pi * frequency * float64(i)
+		signal += complex128(samples[i]) * cmplx.Exp(complex(0, -w))
+		image += complex128(samples[i]) * cmplx.Exp(complex(0, w))
+	}
+
+	return cmplx.Abs(signal) / float64(count), cmplx.Abs(image) / float64(count)
+}
+
+func TestCorrectionFrontendPPM(t *testing.T) {
+	var device = &testFrontend{}
+	var f = CreateCorrectionFrontend(device, CorrectionOptions{PPM: 10})
+
+	if frequency, _ := f.SetCenterFrequency(100000000); frequency != 100000000 {
+		t.Errorf("expected center frequency 100000000 got %d", frequency)
+	}
+
+	if device.centerFrequency != 99999000 {
+		t.Errorf("expected the device to be tuned to 99999000 got %d", device.centerFrequency)
+	}
+
+	if f.MinimumFrequency() != 24000240 {
+		t.Errorf("expected minimum frequency 24000240 got %d", f.MinimumFrequency())
+	}
+}
+
+func TestCorrectionFrontendDCAndIQ(t *testing.T) {
+	var device = &testFrontend{}
+	var f = CreateCorrectionFrontend(device, CorrectionOptions{RemoveDC: true, CorrectIQ: true})
+
+	var received []complex64
+	f.SetSamplesAvailableCallback(func(samples []complex64) {
+		received = append(received, samples...)
+	})
+
+	var input = tone(200000, 0.01, 1.2, 0.1, complex(0.3, -0.2))
+	var _, inputImage = toneLevels(input, 0.01, 10000)
+	for i := 0; i < len(input); i += 4096 {
+		var end = i + 4096
+		if end > len(input) {
+			end = len(input)
+		}
+		device.push(input[i:end])
+	}
+
+	var mean complex64
+	for _, v := range received[len(received)-10000:] {
+		mean += v
+	}
+	mean /= 10000
+
+	if cmplx.Abs(complex128(mean)) > 0.01 {
+		t.Errorf("DC offset was not removed: %v", mean)
+	}
+
+	var signal, image = toneLevels(received, 0.01, 10000)
+	if image > inputImage/20 || image > signal/100 {
+		t.Errorf("IQ imbalance was not corrected: signal %f image %f (image before %f)", signal, image, inputImage)
+	}
+}
@@ -0,0 +1,458 @@
+//go:build hackrf
+// +build hackrf
+
+package frontends
+
+/*
+#cgo LDFLAGS: -lhackrf
+#include <stdlib.h>
+#include <libhackrf/hackrf.h>
+
+extern int hackrfRxCallback(hackrf_transfer *transfer);
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"sync"
+	"unsafe"
+)
+
+// HackRF tunes from 1 MHz to 6 GHz, but frequencies in the protocol are uint32
+const hackrfMaximumFrequency = 4294967295
+const hackrfMinimumFrequency = 1e6
+
+// hackrfDefaultSampleRate keeps the CPU usage reasonable, 20 MS/s is available through SetSampleRate
+const hackrfDefaultSampleRate = 8000000
+
+// hackrfGainStep is the total gain (LNA + VGA) in dB between two gain indexes
+const hackrfGainStep = 4
+const hackrfMaximumGainIndex = 25
+const hackrfMaximumLNAGain = 40
+const hackrfLNAGainStep = 8
+const hackrfMaximumVGAGain = 62
+
+var hackrfSampleRates = []uint32{
+	20000000,
+	16000000,
+	10000000,
+	8000000,
+	5000000,
+	4000000,
+	2000000,
+}
+
+var hackrfLog = SLog.Scope("HackRF Frontend")
+
+// hackrfDevices maps the devices to their frontends for the rx callback, Go pointers can't be given to libhackrf
+var hackrfDevices = map[*C.hackrf_device]*HackRFFrontend{}
+var hackrfDevicesMtx = sync.Mutex{}
+
+// hackrf_init and hackrf_exit are global to libhackrf, so with several HackRF frontends only the first one
+// initializes it and the last one closed de-initializes it
+var hackrfLibMtx sync.Mutex
+var hackrfLibUsers int
+
+func initializeHackRFLib() error {
+	hackrfLibMtx.Lock()
+	defer hackrfLibMtx.Unlock()
+
+	if hackrfLibUsers == 0 {
+		err := hackrfError(C.hackrf_init())
+		if err != nil {
+			return err
+		}
+	}
+	hackrfLibUsers++
+
+	return nil
+}
+
+func deInitializeHackRFLib() {
+	hackrfLibMtx.Lock()
+	defer hackrfLibMtx.Unlock()
+
+	hackrfLibUsers--
+	if hackrfLibUsers == 0 {
+		C.hackrf_exit()
+	}
+}
+
+func init() {
+	RegisterFrontend("hackrf", func(options FrontendOptions) (Frontend, error) {
+		return CreateHackRFFrontend(options.DeviceIndex)
+	})
+}
+
+//export hackrfRxCallback
+func hackrfRxCallback(transfer *C.hackrf_transfer) C.int {
+	hackrfDevicesMtx.Lock()
+	var f = hackrfDevices[transfer.device]
+	hackrfDevicesMtx.Unlock()
+
+	if f != nil && f.cb != nil && transfer.valid_length > 0 {
+		f.cb(tools.Int8ToComplex64(C.GoBytes(unsafe.Pointer(transfer.buffer), transfer.valid_length)))
+	}
+
+	return 0
+}
+
+func hackrfError(result C.int) error {
+	if result == C.HACKRF_SUCCESS {
+		return nil
+	}
+
+	return fmt.Errorf("%s", C.GoString(C.hackrf_error_name(C.enum_hackrf_error(result))))
+}
+
+var errHackRFClosed = errors.New("HackRF is not open")
+
+// HackRFFrontend uses a HackRF One through libhackrf
+type HackRFFrontend struct {
+	device      *C.hackrf_device
+	deviceIndex int
+	cb          SamplesCallback
+
+	deviceSerial    string
+	centerFrequency uint32
+	sampleRate      uint32
+	currentGain     uint8
+	running         bool
+}
+
+// CreateHackRFFrontend creates a frontend for the HackRF with the specified index. The device is opened by Init.
+func CreateHackRFFrontend(deviceIndex int) (Frontend, error) {
+	if deviceIndex < 0 {
+		return nil, fmt.Errorf("invalid HackRF device %d", deviceIndex)
+	}
+
+	return &HackRFFrontend{
+		deviceIndex: deviceIndex,
+		sampleRate:  hackrfDefaultSampleRate,
+	}, nil
+}
+
+// openByIndex opens the device at deviceIndex and reads its serial, so it can be opened by serial after that
+func (f *HackRFFrontend) openByIndex() error {
+	var list = C.hackrf_device_list()
+	if list == nil {
+		return fmt.Errorf("error listing HackRF devices")
+	}
+	defer C.hackrf_device_list_free(list)
+
+	var count = int(list.devicecount)
+	if f.deviceIndex >= count {
+		return fmt.Errorf("no such HackRF device %d (found %d)", f.deviceIndex, count)
+	}
+
+	err := hackrfError(C.hackrf_device_list_open(list, C.int(f.deviceIndex), &f.device))
+	if err != nil {
+		f.device = nil
+		return fmt.Errorf("error opening HackRF device %d: %s", f.deviceIndex, err)
+	}
+
+	var serials = (*[1 << 16]*C.char)(unsafe.Pointer(list.serial_numbers))[:count:count]
+	if serials[f.deviceIndex] != nil {
+		f.deviceSerial = C.GoString(serials[f.deviceIndex])
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) openBySerial() error {
+	var serial = C.CString(f.deviceSerial)
+	defer C.free(unsafe.Pointer(serial))
+
+	err := hackrfError(C.hackrf_open_by_serial(serial, &f.device))
+	if err != nil {
+		f.device = nil
+		return fmt.Errorf("error opening %s: %s", f.deviceSerial, err)
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) GetUintDeviceSerial() uint32 {
+	var serial uint32
+	// The serial is a 32 digits hex string, the last 8 digits are the unique part
+	if len(f.deviceSerial) >= 8 {
+		_, _ = fmt.Sscanf(f.deviceSerial[len(f.deviceSerial)-8:], "%08x", &serial)
+	}
+
+	return serial
+}
+
+func (f *HackRFFrontend) MinimumFrequency() uint32 {
+	return hackrfMinimumFrequency
+}
+
+func (f *HackRFFrontend) MaximumFrequency() uint32 {
+	return hackrfMaximumFrequency
+}
+
+func (f *HackRFFrontend) GetMaximumBandwidth() uint32 {
+	return uint32(float32(f.GetSampleRate()) * 0.75)
+}
+
+func (f *HackRFFrontend) MaximumGainIndex() uint32 {
+	return hackrfMaximumGainIndex
+}
+
+func (f *HackRFFrontend) MaximumDecimationStages() uint32 {
+	return decimationStages(f.GetSampleRate())
+}
+
+func (f *HackRFFrontend) GetDeviceType() uint32 {
+	return protocol.DeviceHackRF
+}
+
+func (f *HackRFFrontend) GetDeviceSerial() string {
+	return f.deviceSerial
+}
+
+func (f *HackRFFrontend) GetMaximumSampleRate() uint32 {
+	return hackrfSampleRates[0]
+}
+
+// SetSampleRate uses the highest supported sample rate that is not above sampleRate. The baseband filter is set to
+// 75% of the sample rate.
+func (f *HackRFFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	if f.device == nil {
+		return f.sampleRate, errHackRFClosed
+	}
+
+	var selected = hackrfSampleRates[len(hackrfSampleRates)-1]
+	for _, v := range hackrfSampleRates {
+		if v <= sampleRate {
+			selected = v
+			break
+		}
+	}
+
+	err := hackrfError(C.hackrf_set_sample_rate(f.device, C.double(selected)))
+	if err != nil {
+		return f.sampleRate, fmt.Errorf("error setting sample rate %d: %s", selected, err)
+	}
+
+	f.sampleRate = selected
+
+	var bandwidth = C.hackrf_compute_baseband_filter_bw(C.uint32_t(selected * 3 / 4))
+	err = hackrfError(C.hackrf_set_baseband_filter_bandwidth(f.device, bandwidth))
+	if err != nil {
+		return f.sampleRate, fmt.Errorf("error setting baseband filter: %s", err)
+	}
+
+	return f.sampleRate, nil
+}
+
+func (f *HackRFFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	if f.device == nil {
+		return f.centerFrequency, errHackRFClosed
+	}
+
+	err := hackrfError(C.hackrf_set_freq(f.device, C.uint64_t(centerFrequency)))
+	if err != nil {
+		return f.centerFrequency, fmt.Errorf("error setting center frequency %d: %s", centerFrequency, err)
+	}
+
+	f.centerFrequency = centerFrequency
+	return f.centerFrequency, nil
+}
+
+func (f *HackRFFrontend) GetAvailableSampleRates() []uint32 {
+	return hackrfSampleRates
+}
+
+func (f *HackRFFrontend) Start() error {
+	if f.device == nil {
+		return errHackRFClosed
+	}
+
+	if !f.running {
+		hackrfLog.Info("Starting")
+		err := hackrfError(C.hackrf_start_rx(f.device, C.hackrf_sample_block_cb_fn(C.hackrfRxCallback), nil))
+		if err != nil {
+			return fmt.Errorf("error starting: %s", err)
+		}
+		f.running = true
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) Stop() error {
+	if f.running {
+		hackrfLog.Info("Stopping")
+		err := hackrfError(C.hackrf_stop_rx(f.device))
+		if err != nil {
+			return fmt.Errorf("error stopping: %s", err)
+		}
+		f.running = false
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) SetAntenna(value string) error {
+	return ErrNotSupported
+}
+
+func (f *HackRFFrontend) SetAGC(agc bool) error {
+	return ErrNotSupported
+}
+
+// SetGain splits the total gain (value * hackrfGainStep dB) between the LNA (first) and the VGA. The RF amplifier is
+// never enabled, since it can be damaged by strong signals.
+func (f *HackRFFrontend) SetGain(value uint8) error {
+	if f.device == nil {
+		return errHackRFClosed
+	}
+
+	if value > hackrfMaximumGainIndex {
+		value = hackrfMaximumGainIndex
+	}
+
+	var totalGain = uint32(value) * hackrfGainStep
+	var lnaGain = totalGain / hackrfLNAGainStep * hackrfLNAGainStep
+	if lnaGain > hackrfMaximumLNAGain {
+		lnaGain = hackrfMaximumLNAGain
+	}
+
+	var vgaGain = totalGain - lnaGain
+	if vgaGain > hackrfMaximumVGAGain {
+		vgaGain = hackrfMaximumVGAGain
+	}
+
+	err := hackrfError(C.hackrf_set_lna_gain(f.device, C.uint32_t(lnaGain)))
+	if err == nil {
+		err = hackrfError(C.hackrf_set_vga_gain(f.device, C.uint32_t(vgaGain)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("error setting gain: %s", err)
+	}
+
+	f.currentGain = value
+	return nil
+}
+
+func (f *HackRFFrontend) GetGain() uint8 {
+	return f.currentGain
+}
+
+// SetBiasT enables the antenna port power
+func (f *HackRFFrontend) SetBiasT(value bool) error {
+	if f.device == nil {
+		return errHackRFClosed
+	}
+
+	var enable = C.uint8_t(0)
+	if value {
+		enable = 1
+	}
+
+	err := hackrfError(C.hackrf_set_antenna_enable(f.device, enable))
+	if err != nil {
+		return fmt.Errorf("error setting Bias-T: %s", err)
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) GetCenterFrequency() uint32 {
+	return f.centerFrequency
+}
+
+func (f *HackRFFrontend) GetName() string {
+	return fmt.Sprintf("%s %s", protocol.DeviceHackRFName, f.deviceSerial)
+}
+
+func (f *HackRFFrontend) GetShortName() string {
+	return "HackRF"
+}
+
+func (f *HackRFFrontend) GetSampleRate() uint32 {
+	return f.sampleRate
+}
+
+func (f *HackRFFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+// Init opens the device, if it's not open yet or was closed by Destroy, and sets the defaults. After the first time,
+// the device is opened by its serial.
+func (f *HackRFFrontend) Init(ctx context.Context) error {
+	if f.device == nil {
+		err := f.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := f.SetSampleRate(hackrfDefaultSampleRate)
+	if err != nil {
+		return err
+	}
+
+	err = f.SetGain(0)
+	if err != nil {
+		return err
+	}
+
+	err = hackrfError(C.hackrf_set_amp_enable(f.device, 0))
+	if err != nil {
+		return fmt.Errorf("error disabling the RF amplifier: %s", err)
+	}
+
+	return nil
+}
+
+func (f *HackRFFrontend) open() error {
+	err := initializeHackRFLib()
+	if err != nil {
+		return fmt.Errorf("error initializing libhackrf: %s", err)
+	}
+
+	if f.deviceSerial == "" {
+		hackrfLog.Info("Opening device %d", f.deviceIndex)
+		err = f.openByIndex()
+	} else {
+		hackrfLog.Info("Opening %s", f.deviceSerial)
+		err = f.openBySerial()
+	}
+
+	if err != nil {
+		deInitializeHackRFLib()
+		return err
+	}
+
+	hackrfDevicesMtx.Lock()
+	hackrfDevices[f.device] = f
+	hackrfDevicesMtx.Unlock()
+
+	return nil
+}
+
+func (f *HackRFFrontend) Destroy() error {
+	if f.device == nil {
+		return nil
+	}
+
+	hackrfLog.Info("De-initializing")
+	_ = f.Stop()
+
+	hackrfDevicesMtx.Lock()
+	delete(hackrfDevices, f.device)
+	hackrfDevicesMtx.Unlock()
+
+	err := hackrfError(C.hackrf_close(f.device))
+	deInitializeHackRFLib()
+	f.device = nil
+
+	return err
+}
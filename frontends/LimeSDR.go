@@ -1,84 +1,155 @@
+//go:build limesdr
+// +build limesdr
+
 package frontends
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/racerxdl/limedrv"
 	"github.com/racerxdl/radioserver/SLog"
 	"github.com/racerxdl/radioserver/protocol"
-	"math"
+	"strconv"
+	"strings"
 )
 
-const limeMaximumFrequency = 3.8e6
-const limeMinimumFrequency = 100e3
+const limeUSBMinimumFrequency = 100e3
+const limeUSBMaximumFrequency = 3.8e9
+const limeMiniMinimumFrequency = 10e6
+const limeMiniMaximumFrequency = 3.5e9
+
+const limeDefaultAntenna = "LNAW"
+
+// limeMaxOverSample is the highest oversampling LMS_SetSampleRate accepts
+const limeMaxOverSample = 32
 
 var limeLog = SLog.Scope("LimeSDR Frontend")
 
+var errLimeClosed = errors.New("LimeSDR is not open")
+
+func init() {
+	RegisterFrontend("limesdr", func(options FrontendOptions) (Frontend, error) {
+		if options.DeviceIndex < 0 || options.Channel < 0 {
+			return nil, fmt.Errorf("invalid LimeSDR device %d / channel %d", options.DeviceIndex, options.Channel)
+		}
+		return CreateLimeSDRFrontend(options.DeviceIndex, options.Channel), nil
+	})
+}
+
 type LimeSDRFrontend struct {
 	device *limedrv.LMSDevice
 	cb     SamplesCallback
 
-	deviceSerial       uint64
-	maxSampleRate      uint32
-	maxDecimationStage uint32
-	currentGain        uint8
-	running            bool
+	deviceIndex     int
+	deviceType      uint32
+	deviceSerial    uint64
+	maxSampleRate   uint32
+	sampleRate      uint32
+	centerFrequency uint32
+	currentGain     uint8
+	running         bool
 
 	availableSampleRates []uint32
 	selectedChannel      *limedrv.LMSChannel
 	selectedChannelIndex int
-	//selectedAntenna      int
+	selectedAntenna      string
 }
 
-func CreateLimeSDRFrontend(deviceIdx int) Frontend {
-	devices := limedrv.GetDevices()
-	if len(devices) == 0 {
-		limeLog.Fatal("No devices found.\n")
+// CreateLimeSDRFrontend creates a frontend for the LimeSDR with the specified index, using the specified RX channel.
+// The device is opened by Init.
+func CreateLimeSDRFrontend(deviceIdx, channel int) Frontend {
+	var f = &LimeSDRFrontend{
+		deviceIndex:          deviceIdx,
+		deviceType:           protocol.DeviceLimeSDRUSB,
+		deviceSerial:         0,
+		maxSampleRate:        30000000, //60000000
+		currentGain:          0,
+		running:              false,
+		selectedChannelIndex: channel,
+		selectedAntenna:      limeDefaultAntenna,
 	}
 
-	if len(devices) < deviceIdx {
-		limeLog.Fatal("No such device %d found.\n", deviceIdx)
+	// Lower sample rates are reached with more oversampling (see overSample), so only the ones it allows are
+	// listed. Clients get lower rates from the decimation stages.
+	var availableSampleRates = make([]uint32, 0)
+	for decimation := uint32(1); 2*decimation <= limeMaxOverSample; decimation *= 2 {
+		availableSampleRates = append(availableSampleRates, f.maxSampleRate/decimation)
 	}
 
-	var device = limedrv.Open(devices[deviceIdx])
+	f.availableSampleRates = availableSampleRates
 
-	var f = &LimeSDRFrontend{
-		device:               device,
-		deviceSerial:         0,
-		maxSampleRate:        0,
-		currentGain:          0,
-		running:              false,
-		selectedChannelIndex: 0,
+	return f
+}
+
+// findDevice returns the device opened before (by its serial) or, the first time, the device at deviceIndex
+func (f *LimeSDRFrontend) findDevice() (limedrv.DeviceInfo, error) {
+	devices := limedrv.GetDevices()
+	if len(devices) == 0 {
+		return limedrv.DeviceInfo{}, fmt.Errorf("no LimeSDR devices found")
 	}
 
-	f.deviceSerial = 0
-	f.maxSampleRate = 30000000 //60000000
+	if f.deviceSerial == 0 {
+		if len(devices) <= f.deviceIndex {
+			return limedrv.DeviceInfo{}, fmt.Errorf("no such LimeSDR device %d (found %d)", f.deviceIndex, len(devices))
+		}
+
+		return devices[f.deviceIndex], nil
+	}
 
-	var availableSampleRates = make([]uint32, 1)
-	availableSampleRates[0] = f.maxSampleRate
+	for _, v := range devices {
+		serial, _ := strconv.ParseUint(v.Serial, 16, 64)
+		if serial == f.deviceSerial {
+			return v, nil
+		}
+	}
 
-	var maxDecimationStage = uint32(0)
-	var calcSR = f.maxSampleRate
+	return limedrv.DeviceInfo{}, fmt.Errorf("%s not found", f.GetName())
+}
 
-	for calcSR >= minimumSampleRate {
-		maxDecimationStage += 1
-		var decim = uint32(math.Pow(2, float64(maxDecimationStage)))
-		calcSR = f.maxSampleRate / decim
-		availableSampleRates = append(availableSampleRates, calcSR)
+// open opens the device at the maximum sample rate with the selected channel and antenna
+func (f *LimeSDRFrontend) open(info limedrv.DeviceInfo) error {
+	f.deviceType = protocol.DeviceLimeSDRUSB
+	if strings.Contains(strings.ToLower(info.DeviceName), "mini") {
+		f.deviceType = protocol.DeviceLimeSDRMini
 	}
 
-	f.availableSampleRates = availableSampleRates
+	f.deviceSerial, _ = strconv.ParseUint(info.Serial, 16, 64)
 
-	f.maxDecimationStage = maxDecimationStage
+	f.device = limedrv.Open(info)
+	if f.selectedChannelIndex >= len(f.device.RXChannels) {
+		f.device.Close()
+		f.device = nil
+		return fmt.Errorf("LimeSDR has no RX channel %d", f.selectedChannelIndex)
+	}
 
 	f.device.
-		SetCallback(func(samples []complex64, _ int, _ uint64) {
-			if f.cb != nil {
+		SetCallback(func(samples []complex64, channel int, _ uint64) {
+			if f.cb != nil && channel == f.selectedChannelIndex {
 				f.cb(samples)
 			}
 		})
 	f.device.SetSampleRate(float64(f.maxSampleRate), 2)
+	f.sampleRate = f.maxSampleRate
+
+	f.selectedChannel = nil
+	f.enableChannel(f.selectedChannelIndex)
+
+	return nil
+}
+
+// enableChannel disables the current RX channel and enables the specified one with the selected antenna
+func (f *LimeSDRFrontend) enableChannel(channel int) {
+	if f.selectedChannel != nil {
+		f.selectedChannel.Disable()
+	}
+
+	f.selectedChannelIndex = channel
+	f.selectedChannel = f.device.RXChannels[channel]
 
-	f.selectedChannel = device.RXChannels[f.selectedChannelIndex]
+	if !f.hasAntenna(f.selectedAntenna) {
+		f.selectedAntenna = f.defaultAntenna()
+	}
 
 	f.selectedChannel.
 		Enable().
@@ -86,11 +157,97 @@ func CreateLimeSDRFrontend(deviceIdx int) Frontend {
 		EnableLPF().
 		SetDigitalLPF(float64(f.maxSampleRate) / 2).
 		EnableDigitalLPF().
-		SetAntennaByName("LNAW")
+		SetAntennaByName(f.selectedAntenna)
 
 	f.device.SetGainNormalized(f.selectedChannelIndex, true, 0.1)
+}
 
-	return f
+func (f *LimeSDRFrontend) hasAntenna(name string) bool {
+	for _, v := range f.selectedChannel.Antennas {
+		if v.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultAntenna returns LNAW (wide band) if the channel has it, otherwise its first usable antenna
+func (f *LimeSDRFrontend) defaultAntenna() string {
+	if f.hasAntenna(limeDefaultAntenna) {
+		return limeDefaultAntenna
+	}
+
+	for _, v := range f.selectedChannel.Antennas {
+		if v.Name != "NONE" {
+			return v.Name
+		}
+	}
+
+	return limeDefaultAntenna
+}
+
+// GetAntennas returns the antennas of the selected RX channel
+func (f *LimeSDRFrontend) GetAntennas() []string {
+	var antennas = make([]string, 0)
+	if f.device == nil {
+		return antennas
+	}
+
+	for _, v := range f.selectedChannel.Antennas {
+		if v.Name != "NONE" {
+			antennas = append(antennas, v.Name)
+		}
+	}
+
+	return antennas
+}
+
+func (f *LimeSDRFrontend) GetAntenna() string {
+	return f.selectedAntenna
+}
+
+func (f *LimeSDRFrontend) GetChannelCount() uint32 {
+	if f.device == nil {
+		return 0
+	}
+
+	return uint32(len(f.device.RXChannels))
+}
+
+func (f *LimeSDRFrontend) GetChannel() uint32 {
+	return uint32(f.selectedChannelIndex)
+}
+
+// SetChannel switches to another RX channel, keeping the center frequency and gain. The stream is restarted if running.
+func (f *LimeSDRFrontend) SetChannel(channel uint32) error {
+	if f.device == nil {
+		return errLimeClosed
+	}
+
+	if channel >= f.GetChannelCount() {
+		return fmt.Errorf("no such RX channel %d", channel)
+	}
+
+	if int(channel) == f.selectedChannelIndex {
+		return nil
+	}
+
+	var running = f.running
+	var centerFrequency = f.GetCenterFrequency()
+
+	_ = f.Stop()
+	f.enableChannel(int(channel))
+	_, _ = f.SetCenterFrequency(centerFrequency)
+	_ = f.SetGain(f.currentGain)
+
+	limeLog.Info("Using RX channel %d", channel)
+
+	if running {
+		return f.Start()
+	}
+
+	return nil
 }
 
 func (f *LimeSDRFrontend) GetUintDeviceSerial() uint32 {
@@ -98,15 +255,23 @@ func (f *LimeSDRFrontend) GetUintDeviceSerial() uint32 {
 }
 
 func (f *LimeSDRFrontend) MinimumFrequency() uint32 {
-	return limeMinimumFrequency
+	if f.deviceType == protocol.DeviceLimeSDRMini {
+		return limeMiniMinimumFrequency
+	}
+
+	return limeUSBMinimumFrequency
 }
 
 func (f *LimeSDRFrontend) MaximumFrequency() uint32 {
-	return limeMaximumFrequency
+	if f.deviceType == protocol.DeviceLimeSDRMini {
+		return limeMiniMaximumFrequency
+	}
+
+	return limeUSBMaximumFrequency
 }
 
 func (f *LimeSDRFrontend) GetMaximumBandwidth() uint32 {
-	return f.maxSampleRate
+	return f.GetSampleRate()
 }
 
 func (f *LimeSDRFrontend) MaximumGainIndex() uint32 {
@@ -114,11 +279,11 @@ func (f *LimeSDRFrontend) MaximumGainIndex() uint32 {
 }
 
 func (f *LimeSDRFrontend) MaximumDecimationStages() uint32 {
-	return f.maxDecimationStage
+	return decimationStages(f.GetSampleRate())
 }
 
 func (f *LimeSDRFrontend) GetDeviceType() uint32 {
-	return protocol.DeviceAirspyOne // TODO: Change to Lime
+	return f.deviceType
 }
 
 func (f *LimeSDRFrontend) GetDeviceSerial() string {
@@ -127,70 +292,143 @@ func (f *LimeSDRFrontend) GetDeviceSerial() string {
 func (f *LimeSDRFrontend) GetMaximumSampleRate() uint32 {
 	return f.maxSampleRate
 }
-func (f *LimeSDRFrontend) SetSampleRate(sampleRate uint32) uint32 {
-	var overSample = 2 * (f.maxSampleRate / sampleRate)
+
+// overSample returns the oversampling used for sampleRate, so the ADC keeps running at twice the maximum sample rate
+func (f *LimeSDRFrontend) overSample(sampleRate uint32) uint32 {
+	return 2 * (f.maxSampleRate / sampleRate)
+}
+
+func (f *LimeSDRFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	if f.device == nil {
+		return f.sampleRate, errLimeClosed
+	}
+
+	if sampleRate == 0 || sampleRate > f.maxSampleRate {
+		return f.sampleRate, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+
+	var overSample = f.overSample(sampleRate)
+	if overSample > limeMaxOverSample {
+		return f.sampleRate, fmt.Errorf("sample rate %d is below the minimum %d", sampleRate, f.maxSampleRate*2/limeMaxOverSample)
+	}
+
 	f.device.SetSampleRate(float64(sampleRate), int(overSample))
 	deviceSr, _ := f.device.GetSampleRate()
-	return uint32(deviceSr)
+	f.selectedChannel.
+		SetLPF(deviceSr / 2).
+		SetDigitalLPF(deviceSr / 2)
+	f.sampleRate = uint32(deviceSr)
+	return f.sampleRate, nil
 }
-func (f *LimeSDRFrontend) SetCenterFrequency(centerFrequency uint32) uint32 {
+func (f *LimeSDRFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	if f.device == nil {
+		return f.centerFrequency, errLimeClosed
+	}
+
 	f.device.SetCenterFrequency(f.selectedChannelIndex, true, float64(centerFrequency))
-	return uint32(f.device.GetCenterFrequency(f.selectedChannelIndex, true))
+	f.centerFrequency = uint32(f.device.GetCenterFrequency(f.selectedChannelIndex, true))
+	return f.centerFrequency, nil
 }
 func (f *LimeSDRFrontend) GetAvailableSampleRates() []uint32 {
 	return f.availableSampleRates
 }
-func (f *LimeSDRFrontend) Start() {
+func (f *LimeSDRFrontend) Start() error {
+	if f.device == nil {
+		return errLimeClosed
+	}
+
 	if !f.running {
 		limeLog.Info("Starting")
 		f.device.Start()
 		f.running = true
 	}
+
+	return nil
 }
-func (f *LimeSDRFrontend) Stop() {
+func (f *LimeSDRFrontend) Stop() error {
 	if f.running {
 		limeLog.Info("Stopping")
 		f.device.Stop()
 		f.running = false
 	}
+
+	return nil
 }
-func (f *LimeSDRFrontend) SetAntenna(value string) {
-	//limeLog.Warn("Airspy Frontend does not support antenna switch. Ignoring...")
+func (f *LimeSDRFrontend) SetAntenna(value string) error {
+	if f.device == nil {
+		return errLimeClosed
+	}
+
+	if !f.hasAntenna(value) {
+		return fmt.Errorf("RX channel %d has no antenna %s (available: %s)", f.selectedChannelIndex, value, strings.Join(f.GetAntennas(), ", "))
+	}
+
+	f.selectedChannel.SetAntennaByName(value)
+	f.selectedAntenna = value
+	limeLog.Info("Using antenna %s", value)
+
+	return nil
 }
-func (f *LimeSDRFrontend) SetAGC(agc bool) {
-	//f.device.SetAGC(agc)
+func (f *LimeSDRFrontend) SetAGC(agc bool) error {
+	return ErrNotSupported
 }
-func (f *LimeSDRFrontend) SetGain(value uint8) {
+func (f *LimeSDRFrontend) SetGain(value uint8) error {
+	if f.device == nil {
+		return errLimeClosed
+	}
+
 	caculatedGain := float64(f.MaximumGainIndex()) * (float64(value) / 256)
 	f.device.SetGainNormalized(f.selectedChannelIndex, true, caculatedGain)
 	f.currentGain = value
+	return nil
 }
 func (f *LimeSDRFrontend) GetGain() uint8 {
 	return f.currentGain
 }
-func (f *LimeSDRFrontend) SetBiasT(value bool) {
-	//f.device.SetBiasT(value)
+func (f *LimeSDRFrontend) SetBiasT(value bool) error {
+	return ErrNotSupported
 }
 func (f *LimeSDRFrontend) GetCenterFrequency() uint32 {
-	return uint32(f.device.GetCenterFrequency(f.selectedChannelIndex, true))
+	return f.centerFrequency
 }
 func (f *LimeSDRFrontend) GetName() string {
-	return "LimeSDR USB"
+	return fmt.Sprintf("%s %s", protocol.DeviceName[f.deviceType], f.GetDeviceSerial())
 }
 func (f *LimeSDRFrontend) GetShortName() string {
 	return "LimeSDR"
 }
 func (f *LimeSDRFrontend) GetSampleRate() uint32 {
-	deviceSr, _ := f.device.GetSampleRate()
-	return uint32(deviceSr)
+	return f.sampleRate
 }
 func (f *LimeSDRFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
 	f.cb = cb
 }
-func (f *LimeSDRFrontend) Init() bool {
-	return true
+
+// Init opens the device, if it's not open yet or was closed by Destroy. After the first time, the device is found by
+// its serial.
+func (f *LimeSDRFrontend) Init(ctx context.Context) error {
+	if f.device != nil {
+		return nil
+	}
+
+	info, err := f.findDevice()
+	if err != nil {
+		return err
+	}
+
+	limeLog.Info("Opening %s", info.DeviceName)
+	return f.open(info)
 }
 
-func (f *LimeSDRFrontend) Destroy() {
+func (f *LimeSDRFrontend) Destroy() error {
+	if f.device == nil {
+		return nil
+	}
+
+	limeLog.Info("De-initializing")
+	_ = f.Stop()
+	f.device.Close()
+	f.device = nil
 
+	return nil
 }
@@ -0,0 +1,21 @@
+//go:build limesdr
+// +build limesdr
+
+package frontends
+
+import "testing"
+
+func TestLimeSDRSampleRates(t *testing.T) {
+	var f = CreateLimeSDRFrontend(0, 0).(*LimeSDRFrontend)
+
+	var sampleRates = f.GetAvailableSampleRates()
+	if len(sampleRates) != 5 || sampleRates[0] != 30000000 || sampleRates[4] != 1875000 {
+		t.Errorf("expected 5 sample rates from 30000000 to 1875000 got %v", sampleRates)
+	}
+
+	for _, v := range sampleRates {
+		if overSample := f.overSample(v); overSample > limeMaxOverSample {
+			t.Errorf("%d needs oversampling %d", v, overSample)
+		}
+	}
+}
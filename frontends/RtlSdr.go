@@ -0,0 +1,375 @@
+//go:build rtlsdr
+// +build rtlsdr
+
+package frontends
+
+/*
+#cgo LDFLAGS: -lrtlsdr
+#include <stdlib.h>
+#include <rtl-sdr.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"hash/crc32"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// rtlSdrReadSize is the size of each synchronous read. librtlsdr needs a multiple of 512.
+const rtlSdrReadSize = 16 * 16384
+const rtlSdrUsbStringSize = 256
+
+var rtlSdrLog = SLog.Scope("RTL-SDR Frontend")
+
+func init() {
+	RegisterFrontend("rtlsdr", func(options FrontendOptions) (Frontend, error) {
+		return CreateRtlSdrFrontend(options.DeviceIndex)
+	})
+}
+
+// RtlSdrFrontend uses a RTL-SDR dongle through librtlsdr
+type RtlSdrFrontend struct {
+	device      *C.rtlsdr_dev_t
+	deviceIndex int
+	cb          SamplesCallback
+
+	deviceSerial string
+	tunerType    uint32
+	gains        []int
+	currentGain  uint8
+
+	running  bool
+	stateMtx sync.Mutex
+	readDone chan bool
+}
+
+var errRtlSdrClosed = errors.New("RTL-SDR is not open")
+
+// CreateRtlSdrFrontend creates a frontend for the RTL-SDR with the specified index. The device is opened by Init.
+func CreateRtlSdrFrontend(deviceIndex int) (Frontend, error) {
+	if deviceIndex < 0 {
+		return nil, fmt.Errorf("invalid RTL-SDR device %d", deviceIndex)
+	}
+
+	return &RtlSdrFrontend{
+		deviceIndex: deviceIndex,
+	}, nil
+}
+
+// open opens the device and reads its serial, tuner type and gain table
+func (f *RtlSdrFrontend) open() error {
+	var count = int(C.rtlsdr_get_device_count())
+	if count == 0 {
+		return fmt.Errorf("no RTL-SDR devices found")
+	}
+
+	if f.deviceIndex >= count {
+		return fmt.Errorf("no such RTL-SDR device %d (found %d)", f.deviceIndex, count)
+	}
+
+	var manufacturer = (*C.char)(C.malloc(rtlSdrUsbStringSize))
+	var product = (*C.char)(C.malloc(rtlSdrUsbStringSize))
+	var serial = (*C.char)(C.malloc(rtlSdrUsbStringSize))
+	defer C.free(unsafe.Pointer(manufacturer))
+	defer C.free(unsafe.Pointer(product))
+	defer C.free(unsafe.Pointer(serial))
+
+	if C.rtlsdr_get_device_usb_strings(C.uint32_t(f.deviceIndex), manufacturer, product, serial) == 0 {
+		f.deviceSerial = C.GoString(serial)
+	}
+
+	if C.rtlsdr_open(&f.device, C.uint32_t(f.deviceIndex)) != 0 {
+		f.device = nil
+		return fmt.Errorf("error opening RTL-SDR device %d", f.deviceIndex)
+	}
+
+	f.tunerType = uint32(C.rtlsdr_get_tuner_type(f.device))
+	if _, ok := rtlSdrTunerRanges[f.tunerType]; !ok {
+		f.tunerType = RtlSdrTunerUnknown
+	}
+
+	f.gains = nil
+	var gainCount = C.rtlsdr_get_tuner_gains(f.device, nil)
+	if gainCount > 0 {
+		var gains = make([]C.int, gainCount)
+		C.rtlsdr_get_tuner_gains(f.device, &gains[0])
+		for _, v := range gains {
+			f.gains = append(f.gains, int(v))
+		}
+	}
+
+	return nil
+}
+
+func (f *RtlSdrFrontend) readLoop() {
+	defer close(f.readDone)
+
+	var buffer = C.malloc(rtlSdrReadSize)
+	defer C.free(buffer)
+
+	for {
+		f.stateMtx.Lock()
+		var running = f.running
+		f.stateMtx.Unlock()
+
+		if !running {
+			break
+		}
+
+		var nRead C.int
+		if C.rtlsdr_read_sync(f.device, buffer, rtlSdrReadSize, &nRead) != 0 {
+			rtlSdrLog.Error("Error reading samples")
+			break
+		}
+
+		if f.cb != nil && nRead > 0 {
+			f.cb(tools.UInt8ToComplex64(C.GoBytes(buffer, nRead&^1)))
+		}
+	}
+}
+
+func (f *RtlSdrFrontend) GetUintDeviceSerial() uint32 {
+	serial, err := strconv.ParseUint(f.deviceSerial, 10, 32)
+	if err != nil {
+		return crc32.ChecksumIEEE([]uint8(f.deviceSerial))
+	}
+
+	return uint32(serial)
+}
+
+func (f *RtlSdrFrontend) MinimumFrequency() uint32 {
+	return rtlSdrTunerRanges[f.tunerType].minimumFrequency
+}
+
+func (f *RtlSdrFrontend) MaximumFrequency() uint32 {
+	return rtlSdrTunerRanges[f.tunerType].maximumFrequency
+}
+
+func (f *RtlSdrFrontend) GetMaximumBandwidth() uint32 {
+	return uint32(float32(f.GetSampleRate()) * 0.8)
+}
+
+// MaximumGainIndex returns the highest index in the tuner gain table
+func (f *RtlSdrFrontend) MaximumGainIndex() uint32 {
+	if len(f.gains) == 0 {
+		return 0
+	}
+
+	return uint32(len(f.gains) - 1)
+}
+
+func (f *RtlSdrFrontend) MaximumDecimationStages() uint32 {
+	return decimationStages(f.GetSampleRate())
+}
+
+func (f *RtlSdrFrontend) GetDeviceType() uint32 {
+	return protocol.DeviceRtlsdr
+}
+
+func (f *RtlSdrFrontend) GetDeviceSerial() string {
+	return f.deviceSerial
+}
+
+func (f *RtlSdrFrontend) GetMaximumSampleRate() uint32 {
+	return rtlSdrSampleRates[0]
+}
+
+// SetSampleRate uses the highest supported sample rate that is not above sampleRate
+func (f *RtlSdrFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	if f.device == nil {
+		return 0, errRtlSdrClosed
+	}
+
+	var selected = rtlSdrSampleRates[len(rtlSdrSampleRates)-1]
+	for _, v := range rtlSdrSampleRates {
+		if v <= sampleRate {
+			selected = v
+			break
+		}
+	}
+
+	if C.rtlsdr_set_sample_rate(f.device, C.uint32_t(selected)) != 0 {
+		return f.GetSampleRate(), fmt.Errorf("error setting sample rate %d", selected)
+	}
+
+	return f.GetSampleRate(), nil
+}
+
+func (f *RtlSdrFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	if f.device == nil {
+		return 0, errRtlSdrClosed
+	}
+
+	if C.rtlsdr_set_center_freq(f.device, C.uint32_t(centerFrequency)) != 0 {
+		return f.GetCenterFrequency(), fmt.Errorf("error setting center frequency %d", centerFrequency)
+	}
+
+	return f.GetCenterFrequency(), nil
+}
+
+func (f *RtlSdrFrontend) GetAvailableSampleRates() []uint32 {
+	return rtlSdrSampleRates
+}
+
+func (f *RtlSdrFrontend) Start() error {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	if f.device == nil {
+		return errRtlSdrClosed
+	}
+
+	if !f.running {
+		rtlSdrLog.Info("Starting")
+		if C.rtlsdr_reset_buffer(f.device) != 0 {
+			return fmt.Errorf("error resetting the RTL-SDR buffer")
+		}
+		f.running = true
+		f.readDone = make(chan bool)
+		go f.readLoop()
+	}
+
+	return nil
+}
+
+func (f *RtlSdrFrontend) Stop() error {
+	f.stateMtx.Lock()
+	if !f.running {
+		f.stateMtx.Unlock()
+		return nil
+	}
+
+	rtlSdrLog.Info("Stopping")
+	f.running = false
+	f.stateMtx.Unlock()
+
+	<-f.readDone
+	return nil
+}
+
+func (f *RtlSdrFrontend) SetAntenna(value string) error {
+	return ErrNotSupported
+}
+
+// SetAGC enables the tuner automatic gain and the RTL2832 AGC
+func (f *RtlSdrFrontend) SetAGC(agc bool) error {
+	if f.device == nil {
+		return errRtlSdrClosed
+	}
+
+	if !agc {
+		if C.rtlsdr_set_agc_mode(f.device, 0) != 0 {
+			return fmt.Errorf("error disabling AGC")
+		}
+		return f.SetGain(f.currentGain)
+	}
+
+	if C.rtlsdr_set_tuner_gain_mode(f.device, 0) != 0 || C.rtlsdr_set_agc_mode(f.device, 1) != 0 {
+		return fmt.Errorf("error enabling AGC")
+	}
+
+	return nil
+}
+
+// SetGain sets the tuner gain to the value at the specified index of the tuner gain table
+func (f *RtlSdrFrontend) SetGain(value uint8) error {
+	if f.device == nil {
+		return errRtlSdrClosed
+	}
+
+	if len(f.gains) == 0 {
+		return ErrNotSupported
+	}
+
+	if uint32(value) > f.MaximumGainIndex() {
+		value = uint8(f.MaximumGainIndex())
+	}
+
+	C.rtlsdr_set_tuner_gain_mode(f.device, 1)
+	if C.rtlsdr_set_tuner_gain(f.device, C.int(f.gains[value])) != 0 {
+		return fmt.Errorf("error setting gain %.1f dB", float32(f.gains[value])/10)
+	}
+	f.currentGain = value
+
+	return nil
+}
+
+func (f *RtlSdrFrontend) GetGain() uint8 {
+	return f.currentGain
+}
+
+func (f *RtlSdrFrontend) SetBiasT(value bool) error {
+	if f.device == nil {
+		return errRtlSdrClosed
+	}
+
+	var on = C.int(0)
+	if value {
+		on = 1
+	}
+
+	if C.rtlsdr_set_bias_tee(f.device, on) != 0 {
+		return fmt.Errorf("error setting Bias-T")
+	}
+
+	return nil
+}
+
+func (f *RtlSdrFrontend) GetCenterFrequency() uint32 {
+	return uint32(C.rtlsdr_get_center_freq(f.device))
+}
+
+func (f *RtlSdrFrontend) GetName() string {
+	return C.GoString(C.rtlsdr_get_device_name(C.uint32_t(f.deviceIndex)))
+}
+
+func (f *RtlSdrFrontend) GetShortName() string {
+	return "RTL-SDR"
+}
+
+func (f *RtlSdrFrontend) GetSampleRate() uint32 {
+	return uint32(C.rtlsdr_get_sample_rate(f.device))
+}
+
+func (f *RtlSdrFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+// Init opens the device, if it's not open yet or was closed by Destroy, and sets the default sample rate
+func (f *RtlSdrFrontend) Init(ctx context.Context) error {
+	if f.device == nil {
+		rtlSdrLog.Info("Opening device %d", f.deviceIndex)
+		err := f.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := f.SetSampleRate(rtlSdrDefaultSampleRate)
+	return err
+}
+
+func (f *RtlSdrFrontend) Destroy() error {
+	if f.device == nil {
+		return nil
+	}
+
+	rtlSdrLog.Info("De-initializing")
+	_ = f.Stop()
+
+	var result = C.rtlsdr_close(f.device)
+	f.device = nil
+	if result != 0 {
+		return fmt.Errorf("error closing RTL-SDR device %d", f.deviceIndex)
+	}
+
+	return nil
+}
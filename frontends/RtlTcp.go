@@ -0,0 +1,404 @@
+package frontends
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// rtl_tcp commands. Each command is 1 byte followed by a big endian uint32 parameter.
+const (
+	RtlTcpCmdSetFrequency      = 0x01
+	RtlTcpCmdSetSampleRate     = 0x02
+	RtlTcpCmdSetGainMode       = 0x03
+	RtlTcpCmdSetGain           = 0x04
+	RtlTcpCmdSetFreqCorrection = 0x05
+	RtlTcpCmdSetIFGain         = 0x06
+	RtlTcpCmdSetTestMode       = 0x07
+	RtlTcpCmdSetAGCMode        = 0x08
+	RtlTcpCmdSetDirectSampling = 0x09
+	RtlTcpCmdSetOffsetTuning   = 0x0a
+	RtlTcpCmdSetGainByIndex    = 0x0d
+	RtlTcpCmdSetBiasTee        = 0x0e
+)
+
+// RtlTcpMagic is the start of the dongle info header
+const RtlTcpMagic = "RTL0"
+
+// RtlTcpHeaderSize is the size of the dongle info header: magic, tuner type and tuner gain count
+const RtlTcpHeaderSize = 12
+
+const rtlTcpConnectTimeout = 10 * time.Second
+const rtlTcpReadSize = 16 * 1024
+
+var rtlTcpLog = SLog.Scope("rtl_tcp Frontend")
+
+func init() {
+	RegisterFrontend("rtltcp", func(options FrontendOptions) (Frontend, error) {
+		if options.Address == "" {
+			return nil, fmt.Errorf("the rtltcp frontend needs a rtl_tcp server address")
+		}
+		return CreateRtlTcpFrontend(options.Address), nil
+	})
+}
+
+// RtlTcpFrontend connects to a rtl_tcp server and uses its uint8 IQ stream as a device
+type RtlTcpFrontend struct {
+	address string
+	conn    net.Conn
+	cb      SamplesCallback
+
+	writeMtx sync.Mutex
+	stateMtx sync.Mutex
+
+	tunerType       uint32
+	gainCount       uint32
+	centerFrequency uint32
+	sampleRate      uint32
+	currentGain     uint8
+	running         bool
+}
+
+// CreateRtlTcpFrontend creates a frontend that connects to the rtl_tcp server at address (host:port)
+func CreateRtlTcpFrontend(address string) Frontend {
+	return &RtlTcpFrontend{
+		address:    address,
+		sampleRate: rtlSdrDefaultSampleRate,
+	}
+}
+
+// ParseRtlTcpHeader parses the dongle info header sent by rtl_tcp when a client connects
+func ParseRtlTcpHeader(data []uint8) (tunerType, gainCount uint32, err error) {
+	if len(data) < RtlTcpHeaderSize || string(data[:4]) != RtlTcpMagic {
+		return 0, 0, fmt.Errorf("invalid rtl_tcp header")
+	}
+
+	tunerType = binary.BigEndian.Uint32(data[4:8])
+	gainCount = binary.BigEndian.Uint32(data[8:12])
+
+	return tunerType, gainCount, nil
+}
+
+// CreateRtlTcpHeader creates the dongle info header sent to rtl_tcp clients
+func CreateRtlTcpHeader(tunerType, gainCount uint32) []uint8 {
+	var buff = new(bytes.Buffer)
+	buff.WriteString(RtlTcpMagic)
+	_ = binary.Write(buff, binary.BigEndian, tunerType)
+	_ = binary.Write(buff, binary.BigEndian, gainCount)
+	return buff.Bytes()
+}
+
+func (f *RtlTcpFrontend) sendCommand(command uint8, parameter uint32) error {
+	var data = make([]uint8, 5)
+	data[0] = command
+	binary.BigEndian.PutUint32(data[1:], parameter)
+
+	f.writeMtx.Lock()
+	defer f.writeMtx.Unlock()
+
+	if f.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	_, err := f.conn.Write(data)
+	if err != nil {
+		return fmt.Errorf("error sending command %d: %s", command, err)
+	}
+
+	return nil
+}
+
+// sendCommands sends each command / parameter pair, stopping at the first error
+func (f *RtlTcpFrontend) sendCommands(commands ...[2]uint32) error {
+	for _, v := range commands {
+		err := f.sendCommand(uint8(v[0]), v[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *RtlTcpFrontend) readLoop(conn net.Conn) {
+	var buffer = make([]uint8, rtlTcpReadSize)
+	var pending = 0
+
+	for {
+		n, err := conn.Read(buffer[pending:])
+		if err != nil {
+			break
+		}
+
+		// Samples are I / Q pairs, keep the last byte if we got an odd number of them
+		var length = (pending + n) &^ 1
+
+		f.stateMtx.Lock()
+		var running = f.running
+		f.stateMtx.Unlock()
+
+		if running && f.cb != nil && length > 0 {
+			f.cb(tools.UInt8ToComplex64(buffer[:length]))
+		}
+
+		pending = copy(buffer, buffer[length:pending+n])
+	}
+
+	rtlTcpLog.Warn("Disconnected from %s", f.address)
+}
+
+func (f *RtlTcpFrontend) GetUintDeviceSerial() uint32 {
+	return 0
+}
+
+func (f *RtlTcpFrontend) MinimumFrequency() uint32 {
+	return rtlSdrTunerRanges[f.tunerType].minimumFrequency
+}
+
+func (f *RtlTcpFrontend) MaximumFrequency() uint32 {
+	return rtlSdrTunerRanges[f.tunerType].maximumFrequency
+}
+
+func (f *RtlTcpFrontend) GetMaximumBandwidth() uint32 {
+	return uint32(float32(f.GetSampleRate()) * 0.8)
+}
+
+// MaximumGainIndex returns the highest gain index accepted by SetGain. rtl_tcp gains are set by index in the tuner
+// gain table.
+func (f *RtlTcpFrontend) MaximumGainIndex() uint32 {
+	if f.gainCount == 0 {
+		return 0
+	}
+
+	return f.gainCount - 1
+}
+
+func (f *RtlTcpFrontend) MaximumDecimationStages() uint32 {
+	return decimationStages(f.GetSampleRate())
+}
+
+func (f *RtlTcpFrontend) GetDeviceType() uint32 {
+	return protocol.DeviceRtlsdr
+}
+
+func (f *RtlTcpFrontend) GetDeviceSerial() string {
+	return f.address
+}
+
+func (f *RtlTcpFrontend) GetMaximumSampleRate() uint32 {
+	return rtlSdrSampleRates[0]
+}
+
+// SetSampleRate uses the highest supported sample rate that is not above sampleRate
+func (f *RtlTcpFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	var selected = rtlSdrSampleRates[len(rtlSdrSampleRates)-1]
+	for _, v := range rtlSdrSampleRates {
+		if v <= sampleRate {
+			selected = v
+			break
+		}
+	}
+
+	err := f.sendCommand(RtlTcpCmdSetSampleRate, selected)
+	if err != nil {
+		return f.GetSampleRate(), err
+	}
+
+	f.stateMtx.Lock()
+	f.sampleRate = selected
+	f.stateMtx.Unlock()
+
+	return selected, nil
+}
+
+func (f *RtlTcpFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	err := f.sendCommand(RtlTcpCmdSetFrequency, centerFrequency)
+	if err != nil {
+		return f.GetCenterFrequency(), err
+	}
+
+	f.stateMtx.Lock()
+	f.centerFrequency = centerFrequency
+	f.stateMtx.Unlock()
+
+	return centerFrequency, nil
+}
+
+func (f *RtlTcpFrontend) GetAvailableSampleRates() []uint32 {
+	return rtlSdrSampleRates
+}
+
+// Start starts delivering samples. rtl_tcp streams as soon as a client connects, so samples are dropped while stopped.
+func (f *RtlTcpFrontend) Start() error {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	if !f.running {
+		rtlTcpLog.Info("Starting")
+		f.running = true
+	}
+
+	return nil
+}
+
+func (f *RtlTcpFrontend) Stop() error {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	if f.running {
+		rtlTcpLog.Info("Stopping")
+		f.running = false
+	}
+
+	return nil
+}
+
+func (f *RtlTcpFrontend) SetAntenna(value string) error {
+	return ErrNotSupported
+}
+
+// SetAGC enables both the tuner automatic gain and the RTL2832 AGC
+func (f *RtlTcpFrontend) SetAGC(agc bool) error {
+	if agc {
+		return f.sendCommands(
+			[2]uint32{RtlTcpCmdSetGainMode, 0},
+			[2]uint32{RtlTcpCmdSetAGCMode, 1},
+		)
+	}
+
+	return f.sendCommands(
+		[2]uint32{RtlTcpCmdSetAGCMode, 0},
+		[2]uint32{RtlTcpCmdSetGainMode, 1},
+		[2]uint32{RtlTcpCmdSetGainByIndex, uint32(f.GetGain())},
+	)
+}
+
+func (f *RtlTcpFrontend) SetGain(value uint8) error {
+	if uint32(value) > f.MaximumGainIndex() {
+		value = uint8(f.MaximumGainIndex())
+	}
+
+	err := f.sendCommands(
+		[2]uint32{RtlTcpCmdSetGainMode, 1},
+		[2]uint32{RtlTcpCmdSetGainByIndex, uint32(value)},
+	)
+	if err != nil {
+		return err
+	}
+
+	f.stateMtx.Lock()
+	f.currentGain = value
+	f.stateMtx.Unlock()
+
+	return nil
+}
+
+func (f *RtlTcpFrontend) GetGain() uint8 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.currentGain
+}
+
+func (f *RtlTcpFrontend) SetBiasT(value bool) error {
+	var parameter = uint32(0)
+	if value {
+		parameter = 1
+	}
+
+	return f.sendCommand(RtlTcpCmdSetBiasTee, parameter)
+}
+
+func (f *RtlTcpFrontend) GetCenterFrequency() uint32 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.centerFrequency
+}
+
+func (f *RtlTcpFrontend) GetName() string {
+	return fmt.Sprintf("%s (rtl_tcp at %s)", protocol.DeviceRtlsdrName, f.address)
+}
+
+func (f *RtlTcpFrontend) GetShortName() string {
+	return "rtl_tcp"
+}
+
+func (f *RtlTcpFrontend) GetSampleRate() uint32 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.sampleRate
+}
+
+func (f *RtlTcpFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+// Init connects to the rtl_tcp server, reads the dongle info header and sets the default sample rate
+func (f *RtlTcpFrontend) Init(ctx context.Context) error {
+	rtlTcpLog.Info("Connecting to %s", f.address)
+
+	var dialer = net.Dialer{Timeout: rtlTcpConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", f.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %s", f.address, err)
+	}
+
+	var header = make([]uint8, RtlTcpHeaderSize)
+	_ = conn.SetReadDeadline(time.Now().Add(rtlTcpConnectTimeout))
+	_, err = io.ReadFull(conn, header)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("error reading dongle info from %s: %s", f.address, err)
+	}
+
+	tunerType, gainCount, err := ParseRtlTcpHeader(header)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("error reading dongle info from %s: %s", f.address, err)
+	}
+
+	if _, ok := rtlSdrTunerRanges[tunerType]; !ok {
+		rtlTcpLog.Warn("Unknown tuner type %d", tunerType)
+		tunerType = RtlSdrTunerUnknown
+	}
+
+	f.tunerType = tunerType
+	f.gainCount = gainCount
+
+	f.writeMtx.Lock()
+	f.conn = conn
+	f.writeMtx.Unlock()
+
+	rtlTcpLog.Info("Connected to %s (tuner type %d, %d gains)", f.address, tunerType, gainCount)
+
+	go f.readLoop(conn)
+
+	_, err = f.SetSampleRate(rtlSdrDefaultSampleRate)
+	return err
+}
+
+func (f *RtlTcpFrontend) Destroy() error {
+	rtlTcpLog.Info("Disconnecting from %s", f.address)
+
+	f.writeMtx.Lock()
+	defer f.writeMtx.Unlock()
+
+	if f.conn != nil {
+		err := f.conn.Close()
+		f.conn = nil
+		return err
+	}
+
+	return nil
+}
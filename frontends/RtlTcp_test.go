@@ -0,0 +1,195 @@
+package frontends
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type rtlTcpCommand struct {
+	command   uint8
+	parameter uint32
+}
+
+// fakeRtlTcpServer accepts a single client, sends the dongle info header and records the commands it receives
+type fakeRtlTcpServer struct {
+	listener net.Listener
+	conn     chan net.Conn
+	commands chan rtlTcpCommand
+}
+
+func startFakeRtlTcpServer(t *testing.T, tunerType, gainCount uint32) *fakeRtlTcpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	var s = &fakeRtlTcpServer{
+		listener: listener,
+		conn:     make(chan net.Conn, 1),
+		commands: make(chan rtlTcpCommand, 64),
+	}
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		_, _ = c.Write(CreateRtlTcpHeader(tunerType, gainCount))
+		s.conn <- c
+
+		var data = make([]uint8, 5)
+		for {
+			_, err := io.ReadFull(c, data)
+			if err != nil {
+				return
+			}
+			s.commands <- rtlTcpCommand{command: data[0], parameter: binary.BigEndian.Uint32(data[1:])}
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeRtlTcpServer) expectCommand(t *testing.T, command uint8, parameter uint32) {
+	t.Helper()
+
+	select {
+	case c := <-s.commands:
+		if c.command != command || c.parameter != parameter {
+			t.Fatalf("expected command %d(%d) got %d(%d)", command, parameter, c.command, c.parameter)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected command %d(%d) got nothing", command, parameter)
+	}
+}
+
+func (s *fakeRtlTcpServer) close() {
+	_ = s.listener.Close()
+}
+
+func TestParseRtlTcpHeader(t *testing.T) {
+	tunerType, gainCount, err := ParseRtlTcpHeader(CreateRtlTcpHeader(RtlSdrTunerR820T, 29))
+	if err != nil || tunerType != RtlSdrTunerR820T || gainCount != 29 {
+		t.Fatalf("expected R820T with 29 gains got %d with %d gains (%v)", tunerType, gainCount, err)
+	}
+
+	_, _, err = ParseRtlTcpHeader([]uint8("RTL1\x00\x00\x00\x05\x00\x00\x00\x1d"))
+	if err == nil {
+		t.Fatalf("expected error for an invalid magic")
+	}
+
+	_, _, err = ParseRtlTcpHeader([]uint8("RTL0"))
+	if err == nil {
+		t.Fatalf("expected error for a short header")
+	}
+}
+
+func TestRtlTcpFrontendCommands(t *testing.T) {
+	var server = startFakeRtlTcpServer(t, RtlSdrTunerE4000, 14)
+	defer server.close()
+
+	var f = CreateRtlTcpFrontend(server.listener.Addr().String())
+	err := f.Init(context.Background())
+	if err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	defer f.Destroy()
+
+	server.expectCommand(t, RtlTcpCmdSetSampleRate, rtlSdrDefaultSampleRate)
+
+	if f.MinimumFrequency() != 52e6 || f.MaximumFrequency() != 2200e6 {
+		t.Errorf("wrong E4000 range: %d - %d", f.MinimumFrequency(), f.MaximumFrequency())
+	}
+
+	if f.MaximumGainIndex() != 13 {
+		t.Errorf("expected maximum gain index 13 got %d", f.MaximumGainIndex())
+	}
+
+	f.SetCenterFrequency(106300000)
+	server.expectCommand(t, RtlTcpCmdSetFrequency, 106300000)
+
+	if sampleRate, _ := f.SetSampleRate(2000000); sampleRate != 1920000 {
+		t.Errorf("expected sample rate 1920000 got %d", sampleRate)
+	}
+	server.expectCommand(t, RtlTcpCmdSetSampleRate, 1920000)
+
+	f.SetGain(20)
+	server.expectCommand(t, RtlTcpCmdSetGainMode, 1)
+	server.expectCommand(t, RtlTcpCmdSetGainByIndex, 13)
+
+	f.SetBiasT(true)
+	server.expectCommand(t, RtlTcpCmdSetBiasTee, 1)
+
+	_ = f.Destroy()
+	if err := f.SetGain(5); err == nil || f.GetGain() != 13 {
+		t.Errorf("expected SetGain to fail after Destroy, got %v (gain %d)", err, f.GetGain())
+	}
+}
+
+func TestRtlTcpFrontendUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	var address = listener.Addr().String()
+	_ = listener.Close()
+
+	var f = CreateRtlTcpFrontend(address)
+	if err := f.Init(context.Background()); err == nil {
+		t.Fatalf("expected Init to fail without a server")
+	}
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := f.Init(ctx); err == nil {
+		t.Fatalf("expected Init to fail with a cancelled context")
+	}
+}
+
+func TestRtlTcpFrontendSamples(t *testing.T) {
+	var server = startFakeRtlTcpServer(t, RtlSdrTunerR820T, 29)
+	defer server.close()
+
+	var f = CreateRtlTcpFrontend(server.listener.Addr().String())
+	var received = make(chan []complex64, 16)
+	f.SetSamplesAvailableCallback(func(samples []complex64) {
+		received <- samples
+	})
+
+	err := f.Init(context.Background())
+	if err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	defer f.Destroy()
+
+	f.Start()
+
+	var conn = <-server.conn
+	// An odd number of bytes first, the last byte must be kept until its pair arrives
+	_, _ = conn.Write([]uint8{128, 0, 255})
+	time.Sleep(50 * time.Millisecond)
+	_, _ = conn.Write([]uint8{128})
+
+	var samples []complex64
+	for len(samples) < 2 {
+		select {
+		case s := <-received:
+			samples = append(samples, s...)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 2 samples got %d", len(samples))
+		}
+	}
+
+	// 127.5 is zero, so 128 is slightly above it
+	var expected = []complex64{complex(0.5/127.5, -1), complex(1, 0.5/127.5)}
+	for i, v := range expected {
+		if samples[i] != v {
+			t.Errorf("sample %d: expected %v got %v", i, v, samples[i])
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package frontends
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const spyserverClientName = "radioserver relay"
+const spyserverConnectTimeout = 10 * time.Second
+
+// spyserverClientVersion is the protocol version sent in the hello. SpyServer rejects clients with a different major.
+var spyserverClientVersion = protocol.Version{Major: 2, Minor: 0, Revision: 1700}
+
+var spyserverLog = SLog.Scope("SpyServer Frontend")
+
+func init() {
+	RegisterFrontend("spyserver", func(options FrontendOptions) (Frontend, error) {
+		if options.Address == "" {
+			return nil, fmt.Errorf("the spyserver frontend needs an upstream address")
+		}
+		return CreateSpyServerFrontend(options.Address, options.IQFormat), nil
+	})
+}
+
+// SpyServerFrontend connects as a client to a remote SpyServer / radioserver and uses its IQ stream as a device.
+// The remote IQ channel becomes the local device: its center frequency is our center frequency and its sample rate
+// (after the remote decimation) is our sample rate. It doesn't reconnect by itself when the remote server drops the
+// connection: the samples stop, and the ServerState watchdog re-opens it.
+type SpyServerFrontend struct {
+	address  string
+	iqFormat uint32
+	conn     net.Conn
+	cb       SamplesCallback
+
+	// writeMtx protects conn and running, which only changes once the remote server got the streaming setting
+	writeMtx sync.Mutex
+	// stateMtx protects what the remote server sends (read by readLoop) and the settings that depend on it
+	stateMtx sync.Mutex
+
+	deviceInfo protocol.DeviceInfo
+	clientSync protocol.ClientSync
+	synced     chan bool
+
+	decimation      uint32
+	centerFrequency uint32
+	currentGain     uint8
+	running         bool
+}
+
+// CreateSpyServerFrontend creates a frontend that pulls IQ from the server at address (host:port) in the specified
+// stream format (protocol.StreamFormatUint8, StreamFormatInt16 or StreamFormatFloat)
+func CreateSpyServerFrontend(address string, iqFormat uint32) Frontend {
+	return &SpyServerFrontend{
+		address:  address,
+		iqFormat: iqFormat,
+		synced:   make(chan bool, 1),
+	}
+}
+
+func (f *SpyServerFrontend) sendCommand(commandType uint32, body []uint8) error {
+	f.writeMtx.Lock()
+	defer f.writeMtx.Unlock()
+
+	return f.writeCommand(commandType, body)
+}
+
+// writeCommand is sendCommand for callers holding writeMtx
+func (f *SpyServerFrontend) writeCommand(commandType uint32, body []uint8) error {
+	var header = protocol.CommandHeader{
+		CommandType: commandType,
+		BodySize:    uint32(len(body)),
+	}
+
+	var buff = new(bytes.Buffer)
+	_ = binary.Write(buff, binary.LittleEndian, header)
+	buff.Write(body)
+
+	if f.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	_, err := f.conn.Write(buff.Bytes())
+	return err
+}
+
+func settingBody(setting uint32, args ...uint32) []uint8 {
+	var buff = new(bytes.Buffer)
+	_ = binary.Write(buff, binary.LittleEndian, setting)
+	_ = binary.Write(buff, binary.LittleEndian, args)
+
+	return buff.Bytes()
+}
+
+func (f *SpyServerFrontend) setSetting(setting uint32, args ...uint32) error {
+	err := f.sendCommand(protocol.CmdSetSetting, settingBody(setting, args...))
+	if err != nil {
+		return fmt.Errorf("error sending %s: %s", protocol.SettingNames[setting], err)
+	}
+
+	return nil
+}
+
+func (f *SpyServerFrontend) sendHello() error {
+	var buff = new(bytes.Buffer)
+	_ = binary.Write(buff, binary.LittleEndian, spyserverClientVersion.ToUint32())
+	buff.WriteString(spyserverClientName)
+
+	return f.sendCommand(protocol.CmdHello, buff.Bytes())
+}
+
+func (f *SpyServerFrontend) readLoop(conn net.Conn) {
+	var headerBuffer = make([]uint8, protocol.MessageHeaderSize)
+
+	for {
+		var header protocol.MessageHeader
+
+		_, err := io.ReadFull(conn, headerBuffer)
+		if err != nil {
+			break
+		}
+
+		_ = binary.Read(bytes.NewReader(headerBuffer), binary.LittleEndian, &header)
+		if header.BodySize > protocol.MaxMessageBodySize {
+			spyserverLog.Error("Message with %d bytes body is too big", header.BodySize)
+			break
+		}
+
+		var body = make([]uint8, header.BodySize)
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			break
+		}
+
+		f.handleMessage(header, body)
+	}
+
+	spyserverLog.Warn("Disconnected from %s. It's re-opened by the watchdog when there are clients", f.address)
+}
+
+func (f *SpyServerFrontend) handleMessage(header protocol.MessageHeader, body []uint8) {
+	switch header.MessageType {
+	case protocol.MsgTypeDeviceInfo:
+		f.stateMtx.Lock()
+		_ = binary.Read(bytes.NewReader(body), binary.LittleEndian, &f.deviceInfo)
+		f.stateMtx.Unlock()
+	case protocol.MsgTypeClientSync:
+		f.stateMtx.Lock()
+		_ = binary.Read(bytes.NewReader(body), binary.LittleEndian, &f.clientSync)
+		f.centerFrequency = f.clientSync.IQCenterFrequency
+		f.currentGain = uint8(f.clientSync.Gain)
+		f.stateMtx.Unlock()
+
+		select {
+		case f.synced <- true:
+		default:
+		}
+	case protocol.MsgTypeServerMessage:
+		if len(body) >= 4 {
+			spyserverLog.Warn("Server Message %d: %s", binary.LittleEndian.Uint32(body), string(body[4:]))
+		}
+	case protocol.MsgTypeUint8IQ:
+		f.pushSamples(tools.UInt8ToComplex64(body))
+	case protocol.MsgTypeInt16IQ:
+		f.pushSamples(tools.Int16ToComplex64(body))
+	case protocol.MsgTypeFloatIQ:
+		f.pushSamples(tools.Float32ToComplex64(body))
+	}
+}
+
+func (f *SpyServerFrontend) pushSamples(samples []complex64) {
+	if f.cb != nil && len(samples) > 0 {
+		f.cb(samples)
+	}
+}
+
+// remoteDeviceInfo returns the last DeviceInfo sent by the remote server
+func (f *SpyServerFrontend) remoteDeviceInfo() protocol.DeviceInfo {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.deviceInfo
+}
+
+func (f *SpyServerFrontend) sampleRateForDecimation(decimation uint32) uint32 {
+	return f.remoteDeviceInfo().MaximumSampleRate / tools.StageToNumber(decimation)
+}
+
+func (f *SpyServerFrontend) GetUintDeviceSerial() uint32 {
+	return f.remoteDeviceInfo().DeviceSerial
+}
+
+// MinimumFrequency returns the device limit if we can retune the remote device, otherwise the remote IQ window
+func (f *SpyServerFrontend) MinimumFrequency() uint32 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	if f.clientSync.CanControl != 0 {
+		return f.deviceInfo.MinimumFrequency
+	}
+
+	return f.clientSync.MinimumIQCenterFrequency
+}
+
+// MaximumFrequency returns the device limit if we can retune the remote device, otherwise the remote IQ window
+func (f *SpyServerFrontend) MaximumFrequency() uint32 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	if f.clientSync.CanControl != 0 {
+		return f.deviceInfo.MaximumFrequency
+	}
+
+	return f.clientSync.MaximumIQCenterFrequency
+}
+
+func (f *SpyServerFrontend) GetMaximumBandwidth() uint32 {
+	return uint32(float32(f.GetSampleRate()) * 0.8)
+}
+
+func (f *SpyServerFrontend) MaximumGainIndex() uint32 {
+	return f.remoteDeviceInfo().GainStageCount
+}
+
+func (f *SpyServerFrontend) MaximumDecimationStages() uint32 {
+	return decimationStages(f.GetSampleRate())
+}
+
+func (f *SpyServerFrontend) GetDeviceType() uint32 {
+	return protocol.DeviceSpyServer
+}
+
+func (f *SpyServerFrontend) GetDeviceSerial() string {
+	return fmt.Sprintf("%08x", f.remoteDeviceInfo().DeviceSerial)
+}
+
+func (f *SpyServerFrontend) GetMaximumSampleRate() uint32 {
+	return f.sampleRateForDecimation(f.remoteDeviceInfo().MinimumIQDecimation)
+}
+
+// SetSampleRate picks the remote decimation that gives the closest sample rate not above sampleRate
+func (f *SpyServerFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	var deviceInfo = f.remoteDeviceInfo()
+	var decimation = deviceInfo.MinimumIQDecimation
+	for decimation < deviceInfo.DecimationStageCount && f.sampleRateForDecimation(decimation) > sampleRate {
+		decimation++
+	}
+
+	err := f.setSetting(protocol.SettingIqDecimation, decimation)
+	if err != nil {
+		return f.GetSampleRate(), err
+	}
+
+	f.stateMtx.Lock()
+	f.decimation = decimation
+	f.stateMtx.Unlock()
+
+	return f.GetSampleRate(), nil
+}
+
+// SetCenterFrequency tunes the remote IQ channel. If the frequency is outside of the remote IQ window and we have
+// control, the remote device is retuned as well (radioserver only).
+func (f *SpyServerFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	f.stateMtx.Lock()
+	var canControl = f.clientSync.CanControl != 0
+	var outsideWindow = centerFrequency < f.clientSync.MinimumIQCenterFrequency ||
+		centerFrequency > f.clientSync.MaximumIQCenterFrequency
+	f.stateMtx.Unlock()
+
+	if canControl && outsideWindow {
+		err := f.setSetting(protocol.SettingDeviceFrequency, centerFrequency)
+		if err != nil {
+			return f.GetCenterFrequency(), err
+		}
+	}
+
+	err := f.setSetting(protocol.SettingIqFrequency, centerFrequency)
+	if err != nil {
+		return f.GetCenterFrequency(), err
+	}
+
+	f.stateMtx.Lock()
+	f.centerFrequency = centerFrequency
+	f.stateMtx.Unlock()
+
+	return centerFrequency, nil
+}
+
+func (f *SpyServerFrontend) GetAvailableSampleRates() []uint32 {
+	var deviceInfo = f.remoteDeviceInfo()
+	var sampleRates = make([]uint32, 0)
+	for i := deviceInfo.MinimumIQDecimation; i <= deviceInfo.DecimationStageCount; i++ {
+		sampleRates = append(sampleRates, f.sampleRateForDecimation(i))
+	}
+
+	return sampleRates
+}
+
+func (f *SpyServerFrontend) Start() error {
+	return f.setStreaming(true)
+}
+
+func (f *SpyServerFrontend) Stop() error {
+	return f.setStreaming(false)
+}
+
+// setStreaming enables or disables the remote stream. running only changes if the setting was sent.
+func (f *SpyServerFrontend) setStreaming(enabled bool) error {
+	f.writeMtx.Lock()
+	defer f.writeMtx.Unlock()
+
+	if f.running == enabled {
+		return nil
+	}
+
+	var value = uint32(0)
+	if enabled {
+		spyserverLog.Info("Starting")
+		value = 1
+	} else {
+		spyserverLog.Info("Stopping")
+	}
+
+	err := f.writeCommand(protocol.CmdSetSetting, settingBody(protocol.SettingStreamingEnabled, value))
+	if err != nil {
+		return fmt.Errorf("error sending %s: %s", protocol.SettingNames[protocol.SettingStreamingEnabled], err)
+	}
+
+	f.running = enabled
+	return nil
+}
+
+func (f *SpyServerFrontend) SetAntenna(value string) error {
+	return ErrNotSupported
+}
+
+func (f *SpyServerFrontend) SetAGC(agc bool) error {
+	return ErrNotSupported
+}
+
+// SetGain changes the remote gain. It only works if the remote server gave us control.
+func (f *SpyServerFrontend) SetGain(value uint8) error {
+	err := f.setSetting(protocol.SettingGain, uint32(value))
+	if err != nil {
+		return err
+	}
+
+	f.stateMtx.Lock()
+	f.currentGain = value
+	f.stateMtx.Unlock()
+
+	return nil
+}
+
+func (f *SpyServerFrontend) GetGain() uint8 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.currentGain
+}
+
+func (f *SpyServerFrontend) SetBiasT(value bool) error {
+	return ErrNotSupported
+}
+
+func (f *SpyServerFrontend) GetCenterFrequency() uint32 {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+
+	return f.centerFrequency
+}
+
+func (f *SpyServerFrontend) GetName() string {
+	return fmt.Sprintf("%s (%s at %s)", protocol.DeviceSpyserverName, protocol.DeviceName[f.remoteDeviceInfo().DeviceType], f.address)
+}
+
+func (f *SpyServerFrontend) GetShortName() string {
+	return "SpyServer"
+}
+
+func (f *SpyServerFrontend) GetSampleRate() uint32 {
+	f.stateMtx.Lock()
+	var decimation = f.decimation
+	f.stateMtx.Unlock()
+
+	return f.sampleRateForDecimation(decimation)
+}
+
+func (f *SpyServerFrontend) SetSamplesAvailableCallback(cb SamplesCallback) {
+	f.cb = cb
+}
+
+// Init connects to the remote server, waits for its device info and sets up an IQ only stream
+func (f *SpyServerFrontend) Init(ctx context.Context) error {
+	spyserverLog.Info("Connecting to %s", f.address)
+
+	var dialer = net.Dialer{Timeout: spyserverConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", f.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %s", f.address, err)
+	}
+
+	f.writeMtx.Lock()
+	f.conn = conn
+	f.writeMtx.Unlock()
+
+	go f.readLoop(conn)
+
+	err = f.sendHello()
+	if err != nil {
+		_ = f.Destroy()
+		return fmt.Errorf("error sending hello: %s", err)
+	}
+
+	select {
+	case <-f.synced:
+	case <-time.After(spyserverConnectTimeout):
+		_ = f.Destroy()
+		return fmt.Errorf("timeout waiting for %s device info", f.address)
+	case <-ctx.Done():
+		_ = f.Destroy()
+		return ctx.Err()
+	}
+
+	f.stateMtx.Lock()
+	f.decimation = f.deviceInfo.MinimumIQDecimation
+	var decimation = f.decimation
+	var deviceType = f.deviceInfo.DeviceType
+	var centerFrequency = f.clientSync.DeviceCenterFrequency
+	f.stateMtx.Unlock()
+
+	spyserverLog.Info("Connected to %s (%s)", f.address, protocol.DeviceName[deviceType])
+
+	var settings = [][]uint32{
+		{protocol.SettingStreamingMode, protocol.StreamModeIQOnly},
+		{protocol.SettingIqFormat, f.iqFormat},
+		{protocol.SettingIqDecimation, decimation},
+	}
+
+	for _, v := range settings {
+		err = f.setSetting(v[0], v[1])
+		if err != nil {
+			_ = f.Destroy()
+			return err
+		}
+	}
+
+	_, err = f.SetCenterFrequency(centerFrequency)
+	return err
+}
+
+func (f *SpyServerFrontend) Destroy() error {
+	spyserverLog.Info("Disconnecting from %s", f.address)
+
+	f.writeMtx.Lock()
+	defer f.writeMtx.Unlock()
+
+	f.running = false
+	if f.conn != nil {
+		err := f.conn.Close()
+		f.conn = nil
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package frontends
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeSpyServer accepts a single client, answers its hello with a DeviceInfo and a ClientSync and then keeps
+// sending the DeviceInfo again, like a server whose device changed. Commands are read and ignored.
+func startFakeSpyServer(t *testing.T, deviceInfo protocol.DeviceInfo, clientSync protocol.ClientSync) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	var message = func(messageType uint32, body interface{}) []uint8 {
+		var data = tools.StructToBytes(body)
+		var header = protocol.MessageHeader{MessageType: messageType, BodySize: uint32(len(data))}
+		return append(tools.StructToBytes(header), data...)
+	}
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		var hello = make([]uint8, protocol.CommandHeaderSize)
+		if _, err := io.ReadFull(c, hello); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, make([]uint8, binary.LittleEndian.Uint32(hello[4:]))); err != nil {
+			return
+		}
+
+		_, _ = c.Write(append(message(protocol.MsgTypeDeviceInfo, deviceInfo), message(protocol.MsgTypeClientSync, clientSync)...))
+
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, c)
+		}()
+
+		for {
+			time.Sleep(time.Millisecond)
+			if _, err := c.Write(message(protocol.MsgTypeDeviceInfo, deviceInfo)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return listener
+}
+
+var fakeSpyServerDeviceInfo = protocol.DeviceInfo{
+	DeviceType:           protocol.DeviceAirspyOne,
+	MaximumSampleRate:    2500000,
+	DecimationStageCount: 4,
+	MinimumFrequency:     24000000,
+	MaximumFrequency:     1800000000,
+}
+
+var fakeSpyServerClientSync = protocol.ClientSync{
+	DeviceCenterFrequency:    106300000,
+	IQCenterFrequency:        106300000,
+	MinimumIQCenterFrequency: 105300000,
+	MaximumIQCenterFrequency: 107300000,
+}
+
+func TestSpyServerSampleRates(t *testing.T) {
+	var listener = startFakeSpyServer(t, fakeSpyServerDeviceInfo, fakeSpyServerClientSync)
+	defer listener.Close()
+
+	var f = CreateSpyServerFrontend(listener.Addr().String(), protocol.StreamFormatInt16)
+	err := f.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer f.Destroy()
+
+	// The DeviceInfo is replaced by the read loop meanwhile
+	for i := 0; i < 50; i++ {
+		var sampleRates = f.GetAvailableSampleRates()
+		if len(sampleRates) != 5 || sampleRates[0] != 2500000 || sampleRates[4] != 156250 {
+			t.Fatalf("unexpected sample rates %v", sampleRates)
+		}
+
+		sampleRate, err := f.SetSampleRate(700000)
+		if err != nil || sampleRate != 625000 || f.GetSampleRate() != 625000 {
+			t.Fatalf("expected 625000 got %d / %d (%v)", sampleRate, f.GetSampleRate(), err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSpyServerStopError(t *testing.T) {
+	var listener = startFakeSpyServer(t, fakeSpyServerDeviceInfo, fakeSpyServerClientSync)
+	defer listener.Close()
+
+	var f = CreateSpyServerFrontend(listener.Addr().String(), protocol.StreamFormatInt16).(*SpyServerFrontend)
+	err := f.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer f.Destroy()
+
+	if err := f.Start(); err != nil {
+		t.Fatalf("error starting: %s", err)
+	}
+
+	// The remote server never got the setting, so the stream is still running
+	_ = f.conn.Close()
+	if err := f.Stop(); err == nil || !f.running {
+		t.Errorf("expected Stop to fail and keep running got %v (running %t)", err, f.running)
+	}
+}
@@ -1,33 +1,46 @@
 package frontends
 
+import (
+	"context"
+	"errors"
+)
+
 const SampleTypeFloatIQ = 0
 const SampleTypeS16IQ = 1
 const SampleTypeS8IQ = 2
 const minimumSampleRate = 10e3
 
+// ErrNotSupported is returned when a frontend does not have the feature of a setting (for example AGC)
+var ErrNotSupported = errors.New("not supported by this frontend")
+
+// Frontend is a device that delivers samples. Creating a frontend does not open the device, that is done by Init,
+// so a frontend can be created while its device is not available and Init retried later. Destroy closes the device
+// and Init can open it again.
 type Frontend interface {
 	GetDeviceType() uint32
 	GetDeviceSerial() string
 	GetUintDeviceSerial() uint32
 	GetMaximumSampleRate() uint32
 	GetMaximumBandwidth() uint32
-	SetSampleRate(sampleRate uint32) uint32
-	SetCenterFrequency(centerFrequency uint32) uint32
+	// SetSampleRate and SetCenterFrequency return the value actually applied by the device
+	SetSampleRate(sampleRate uint32) (uint32, error)
+	SetCenterFrequency(centerFrequency uint32) (uint32, error)
 	GetAvailableSampleRates() []uint32
-	Start()
-	Stop()
-	SetAntenna(value string)
-	SetAGC(agc bool)
-	SetGain(value uint8)
-	SetBiasT(value bool)
+	Start() error
+	Stop() error
+	SetAntenna(value string) error
+	SetAGC(agc bool) error
+	SetGain(value uint8) error
+	SetBiasT(value bool) error
 	GetCenterFrequency() uint32
 	GetName() string
 	GetShortName() string
 	GetSampleRate() uint32
 	GetGain() uint8
 	SetSamplesAvailableCallback(cb SamplesCallback)
-	Init() bool
-	Destroy()
+	// Init opens the device. Network frontends give up connecting when ctx is done.
+	Init(ctx context.Context) error
+	Destroy() error
 	MinimumFrequency() uint32
 	MaximumFrequency() uint32
 	MaximumGainIndex() uint32
@@ -35,3 +48,29 @@ type Frontend interface {
 }
 
 type SamplesCallback func(samples []complex64)
+
+// decimationStages returns how many times sampleRate can be halved before going below minimumSampleRate
+func decimationStages(sampleRate uint32) uint32 {
+	var stages = uint32(0)
+	var calcSR = sampleRate
+
+	for calcSR >= minimumSampleRate {
+		stages += 1
+		calcSR = sampleRate >> stages
+	}
+
+	return stages
+}
+
+// AntennaSelector is implemented by frontends that have more than one antenna input
+type AntennaSelector interface {
+	GetAntennas() []string
+	GetAntenna() string
+}
+
+// ChannelSelector is implemented by frontends that have more than one RX channel
+type ChannelSelector interface {
+	GetChannelCount() uint32
+	GetChannel() uint32
+	SetChannel(channel uint32) error
+}
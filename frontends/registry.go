@@ -0,0 +1,86 @@
+package frontends
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FrontendOptions are the settings used to create a frontend. Each frontend only uses the ones that apply to it.
+type FrontendOptions struct {
+	// DeviceIndex selects the device when more than one is connected
+	DeviceIndex int
+	// Channel selects the RX channel in devices with more than one
+	Channel int
+	// Address of the remote server for network frontends
+	Address string
+	// IQFormat requested from remote servers that support more than one
+	IQFormat uint32
+}
+
+// FrontendFactory creates a frontend. Hardware frontends register their factory from files behind build tags, so
+// radioserver can be built without their libraries.
+type FrontendFactory func(options FrontendOptions) (Frontend, error)
+
+var frontendFactories = map[string]FrontendFactory{}
+var frontendFactoriesMtx = sync.Mutex{}
+
+// hardwareFrontends are the frontends DefaultFrontend picks from, in order
+var hardwareFrontends = []string{"airspy", "limesdr", "rtlsdr", "hackrf"}
+
+// RegisterFrontend makes a frontend available to CreateFrontend with the specified name
+func RegisterFrontend(name string, factory FrontendFactory) {
+	frontendFactoriesMtx.Lock()
+	defer frontendFactoriesMtx.Unlock()
+
+	frontendFactories[name] = factory
+}
+
+// AvailableFrontends returns the names of the frontends included in this build
+func AvailableFrontends() []string {
+	frontendFactoriesMtx.Lock()
+	defer frontendFactoriesMtx.Unlock()
+
+	var names = make([]string, 0, len(frontendFactories))
+	for name := range frontendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DefaultFrontend returns the frontend used when none is configured: the first hardware frontend in this build. The
+// network frontends need an address, so builds without hardware frontends have no default.
+func DefaultFrontend() (string, error) {
+	frontendFactoriesMtx.Lock()
+	defer frontendFactoriesMtx.Unlock()
+
+	for _, name := range hardwareFrontends {
+		if _, ok := frontendFactories[name]; ok {
+			return name, nil
+		}
+	}
+
+	var names = make([]string, 0, len(frontendFactories))
+	for name := range frontendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return "", fmt.Errorf("no hardware frontend in this build, set the frontend Type to one of: %s", strings.Join(names, ", "))
+}
+
+// CreateFrontend creates the frontend registered with the specified name
+func CreateFrontend(name string, options FrontendOptions) (Frontend, error) {
+	frontendFactoriesMtx.Lock()
+	var factory, ok = frontendFactories[name]
+	frontendFactoriesMtx.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("frontend %q is not available in this build (available: %s)", name, strings.Join(AvailableFrontends(), ", "))
+	}
+
+	return factory(options)
+}
@@ -0,0 +1,49 @@
+package frontends
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryPureGoFrontends(t *testing.T) {
+	var available = AvailableFrontends()
+
+	for _, name := range []string{"rtltcp", "spyserver"} {
+		var found = false
+		for _, v := range available {
+			found = found || v == name
+		}
+		if !found {
+			t.Errorf("frontend %s should always be available, got %v", name, available)
+		}
+	}
+}
+
+func TestRegistryDefaultFrontend(t *testing.T) {
+	name, err := DefaultFrontend()
+	if err == nil {
+		for _, v := range []string{"rtltcp", "spyserver"} {
+			if name == v {
+				t.Errorf("expected a hardware frontend as default got %s", name)
+			}
+		}
+		return
+	}
+
+	// Pure Go build
+	if !strings.Contains(err.Error(), "rtltcp, spyserver") {
+		t.Errorf("expected the available frontends in the error got %s", err)
+	}
+}
+
+func TestRegistryUnknownFrontend(t *testing.T) {
+	_, err := CreateFrontend("nonexistent", FrontendOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not available in this build") {
+		t.Fatalf("expected an error for an unknown frontend, got %v", err)
+	}
+
+	_, err = CreateFrontend("spyserver", FrontendOptions{})
+	if err == nil {
+		t.Fatalf("expected an error creating the spyserver frontend without address")
+	}
+}
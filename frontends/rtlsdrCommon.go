@@ -0,0 +1,42 @@
+package frontends
+
+// RTL-SDR tuner types, in the same order as librtlsdr. rtl_tcp sends them in the dongle info header.
+const (
+	RtlSdrTunerUnknown = 0
+	RtlSdrTunerE4000   = 1
+	RtlSdrTunerFC0012  = 2
+	RtlSdrTunerFC0013  = 3
+	RtlSdrTunerFC2580  = 4
+	RtlSdrTunerR820T   = 5
+	RtlSdrTunerR828D   = 6
+)
+
+// rtlSdrDefaultSampleRate is the highest sample rate that works without dropping samples on most dongles
+const rtlSdrDefaultSampleRate = 2400000
+
+// rtlSdrSampleRates are the sample rates offered for RTL-SDR dongles, from the highest
+var rtlSdrSampleRates = []uint32{
+	2400000,
+	2160000,
+	2048000,
+	1920000,
+	1792000,
+	1536000,
+	1024000,
+	250000,
+}
+
+type rtlSdrTunerRange struct {
+	minimumFrequency uint32
+	maximumFrequency uint32
+}
+
+var rtlSdrTunerRanges = map[uint32]rtlSdrTunerRange{
+	RtlSdrTunerUnknown: {24e6, 1766e6},
+	RtlSdrTunerE4000:   {52e6, 2200e6},
+	RtlSdrTunerFC0012:  {22e6, 948.6e6},
+	RtlSdrTunerFC0013:  {22e6, 1100e6},
+	RtlSdrTunerFC2580:  {146e6, 1308e6},
+	RtlSdrTunerR820T:   {24e6, 1766e6},
+	RtlSdrTunerR828D:   {24e6, 1766e6},
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/protocol"
+	"net"
+	"time"
+)
+
+const rejectWriteTimeout = 5 * time.Second
+
+// rejectLog is limited so a connection flood doesn't flood the log too
+var rejectLog = tcpSlog.RateLimited(time.Second)
+
+type serverListener struct {
+	net.Listener
+	config *ListenerConfig
+	guard  *connectionGuard
+}
+
+func listenAll(configs []ListenerConfig) ([]*serverListener, error) {
+	var listeners = make([]*serverListener, 0)
+
+	for i := range configs {
+		l, err := listenOne(&configs[i])
+		if err != nil {
+			for _, v := range listeners {
+				_ = v.Close()
+			}
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func listenOne(config *ListenerConfig) (*serverListener, error) {
+	guard, err := createConnectionGuard(&config.LimitsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var listenConfig = net.ListenConfig{
+		KeepAlive: serverConfig.Timeouts.KeepAlive(),
+	}
+
+	l, err := listenConfig.Listen(context.Background(), config.Network, config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.BuildTLSConfig()
+		if err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+
+		l = tls.NewListener(l, tlsConfig)
+		tcpSlog.Info("Listening with TLS at %s (%s)", l.Addr(), config.Network)
+	} else {
+		tcpSlog.Info("Listening at %s (%s)", l.Addr(), config.Network)
+	}
+
+	return &serverListener{
+		Listener: l,
+		config:   config,
+		guard:    guard,
+	}, nil
+}
+
+// serverState returns the frontend served by this listener
+func (l *serverListener) serverState() *StateModels.ServerState {
+	return findServerState(l.config.Frontend)
+}
+
+// admit checks both the global and the listener limits. On failure it also returns the guard that rejected the client.
+func (l *serverListener) admit(ip net.IP) (*connectionGuard, error) {
+	err := globalGuard.admit(ip)
+	if err != nil {
+		return globalGuard, err
+	}
+
+	err = l.guard.admit(ip)
+	if err != nil {
+		globalGuard.release(ip)
+		return l.guard, err
+	}
+
+	return nil, nil
+}
+
+func (l *serverListener) release(ip net.IP, protocolErrors int) {
+	l.guard.release(ip)
+	globalGuard.release(ip)
+
+	l.guard.reportProtocolErrors(ip, protocolErrors)
+	globalGuard.reportProtocolErrors(ip, protocolErrors)
+}
+
+func (l *serverListener) reject(c net.Conn, guard *connectionGuard, reason error) {
+	rejectLog.Warn("Rejecting %s at %s: %s", c.RemoteAddr(), l.Addr(), reason)
+
+	if isPoliteRejection(reason) {
+		_ = c.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		_, _ = c.Write(StateModels.CreateServerMessage(ServerVersion, 0, protocol.ServerMessageRejected, guard.rejectMessage(reason)))
+	}
+
+	_ = c.Close()
+}
+
+// rejectUnavailable tells the client (rtl_tcp clients can't be told) that the frontend of this listener is not
+// available
+func (l *serverListener) rejectUnavailable(c net.Conn, reason error) {
+	rejectLog.Warn("Rejecting %s at %s: frontend unavailable: %s", c.RemoteAddr(), l.Addr(), reason)
+
+	if l.config.Protocol != "rtltcp" {
+		_ = c.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		_, _ = c.Write(StateModels.CreateServerMessage(ServerVersion, 0, protocol.ServerMessageRejected, fmt.Sprintf("Frontend unavailable: %s", reason)))
+	}
+
+	_ = c.Close()
+}
+
+func (l *serverListener) acceptLoop() {
+	for tcpServerStatus {
+		c, err := l.Accept()
+		if err != nil {
+			if tcpServerStatus {
+				tcpSlog.Error("Error accepting client at %s: %s", l.Addr(), err)
+			}
+			tcpServerStatus = false
+			break
+		}
+
+		err = l.serverState().FrontendUnavailable()
+		if err != nil {
+			go l.rejectUnavailable(c, err)
+			continue
+		}
+
+		guard, err := l.admit(remoteIP(c.RemoteAddr()))
+		if err != nil {
+			go l.reject(c, guard, err)
+			continue
+		}
+
+		if l.config.Protocol == "rtltcp" {
+			go handleRtlTcpConnection(c, l)
+		} else {
+			go handleConnection(c, l)
+		}
+	}
+}
+
+// bandwidthLimits merges the global and listener limits for a new client
+func (l *serverListener) bandwidthLimits() StateModels.BandwidthLimits {
+	var policy = l.config.BandwidthPolicy
+	if policy == "" {
+		policy = serverConfig.Limits.BandwidthPolicy
+	}
+
+	var maxClientBandwidth = l.config.MaxClientBandwidth
+	var globalMaxClientBandwidth = serverConfig.Limits.MaxClientBandwidth
+	if maxClientBandwidth == 0 || (globalMaxClientBandwidth != 0 && globalMaxClientBandwidth < maxClientBandwidth) {
+		maxClientBandwidth = globalMaxClientBandwidth
+	}
+
+	return StateModels.BandwidthLimits{
+		MaxClientBandwidth:   maxClientBandwidth,
+		MaxListenerBandwidth: l.config.MaxBandwidth,
+		MaxServerBandwidth:   serverConfig.Limits.MaxBandwidth,
+		Listener:             l.Addr().String(),
+		Reject:               policy == "reject",
+	}
+}
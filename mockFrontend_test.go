@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/protocol"
+	"sync"
+)
+
+const mockCenterFrequency = 106300000
+const mockSampleRate = 2500000
+
+var mockAntennas = []string{"RX1", "RX2"}
+
+// mockFrontend is a Frontend that does not need any hardware. Samples are only delivered through pushSamples.
+// The server calls it from the client goroutines and the watchdog, so every field is guarded by the mutex.
+type mockFrontend struct {
+	sync.Mutex
+	centerFrequency uint32
+	sampleRate      uint32
+	gain            uint8
+	antenna         string
+	agc             bool
+	biasT           bool
+	running         bool
+	channelCount    uint32
+	channel         uint32
+	initCount       int
+	destroyCount    int
+	initErr         error
+	cb              frontends.SamplesCallback
+}
+
+func createMockFrontend() *mockFrontend {
+	return &mockFrontend{
+		centerFrequency: mockCenterFrequency,
+		sampleRate:      mockSampleRate,
+		antenna:         mockAntennas[0],
+		channelCount:    1,
+	}
+}
+
+func (f *mockFrontend) pushSamples(samples []complex64) {
+	f.Lock()
+	var cb = f.cb
+	var running = f.running
+	f.Unlock()
+
+	if cb != nil && running {
+		cb(samples)
+	}
+}
+
+// openCounts returns how many times the frontend was initialized and destroyed
+func (f *mockFrontend) openCounts() (initCount, destroyCount int) {
+	f.Lock()
+	defer f.Unlock()
+	return f.initCount, f.destroyCount
+}
+
+func (f *mockFrontend) isRunning() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.running
+}
+
+func (f *mockFrontend) GetDeviceType() uint32        { return protocol.DeviceAirspyOne }
+func (f *mockFrontend) GetDeviceSerial() string      { return "0000cafe" }
+func (f *mockFrontend) GetUintDeviceSerial() uint32  { return 0xcafe }
+func (f *mockFrontend) GetMaximumSampleRate() uint32 { return mockSampleRate }
+func (f *mockFrontend) GetMaximumBandwidth() uint32 {
+	f.Lock()
+	defer f.Unlock()
+	return f.sampleRate * 8 / 10
+}
+func (f *mockFrontend) GetAvailableSampleRates() []uint32 {
+	return []uint32{mockSampleRate, mockSampleRate / 2}
+}
+func (f *mockFrontend) SetSampleRate(sampleRate uint32) (uint32, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.sampleRate = sampleRate
+	return f.sampleRate, nil
+}
+func (f *mockFrontend) SetCenterFrequency(centerFrequency uint32) (uint32, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.centerFrequency = centerFrequency
+	return f.centerFrequency, nil
+}
+func (f *mockFrontend) Start() error {
+	f.Lock()
+	defer f.Unlock()
+	f.running = true
+	return nil
+}
+func (f *mockFrontend) Stop() error {
+	f.Lock()
+	defer f.Unlock()
+	f.running = false
+	return nil
+}
+func (f *mockFrontend) SetAntenna(value string) error {
+	f.Lock()
+	defer f.Unlock()
+	for _, antenna := range mockAntennas {
+		if antenna == value {
+			f.antenna = value
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid antenna %s", value)
+}
+func (f *mockFrontend) GetChannelCount() uint32 {
+	f.Lock()
+	defer f.Unlock()
+	return f.channelCount
+}
+func (f *mockFrontend) GetChannel() uint32 {
+	f.Lock()
+	defer f.Unlock()
+	return f.channel
+}
+
+// SetChannel resets the antenna, like LimeSDR does when the new channel doesn't have it
+func (f *mockFrontend) SetChannel(channel uint32) error {
+	f.Lock()
+	defer f.Unlock()
+	if channel >= f.channelCount {
+		return fmt.Errorf("no such RX channel %d", channel)
+	}
+	f.channel = channel
+	f.antenna = mockAntennas[0]
+	return nil
+}
+func (f *mockFrontend) GetAntennas() []string { return mockAntennas }
+func (f *mockFrontend) GetAntenna() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.antenna
+}
+func (f *mockFrontend) SetAGC(agc bool) error {
+	f.Lock()
+	defer f.Unlock()
+	f.agc = agc
+	return nil
+}
+func (f *mockFrontend) SetGain(value uint8) error {
+	f.Lock()
+	defer f.Unlock()
+	f.gain = value
+	return nil
+}
+func (f *mockFrontend) SetBiasT(value bool) error {
+	f.Lock()
+	defer f.Unlock()
+	f.biasT = value
+	return nil
+}
+func (f *mockFrontend) GetCenterFrequency() uint32 {
+	f.Lock()
+	defer f.Unlock()
+	return f.centerFrequency
+}
+func (f *mockFrontend) GetName() string      { return "Mock Frontend" }
+func (f *mockFrontend) GetShortName() string { return "Mock" }
+func (f *mockFrontend) GetSampleRate() uint32 {
+	f.Lock()
+	defer f.Unlock()
+	return f.sampleRate
+}
+func (f *mockFrontend) GetGain() uint8 {
+	f.Lock()
+	defer f.Unlock()
+	return f.gain
+}
+
+// Init fails with initErr, like a device that is not plugged in
+func (f *mockFrontend) Init(ctx context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	f.initCount++
+	return f.initErr
+}
+
+// Destroy loses the settings like a device that was re-opened
+func (f *mockFrontend) Destroy() error {
+	f.Lock()
+	defer f.Unlock()
+	f.destroyCount++
+	f.gain = 0
+	f.centerFrequency = mockCenterFrequency
+	return nil
+}
+func (f *mockFrontend) MinimumFrequency() uint32 { return 24e6 }
+func (f *mockFrontend) MaximumFrequency() uint32 { return 1.8e9 }
+func (f *mockFrontend) MaximumGainIndex() uint32 { return 16 }
+func (f *mockFrontend) MaximumDecimationStages() uint32 {
+	return 8
+}
+func (f *mockFrontend) SetSamplesAvailableCallback(cb frontends.SamplesCallback) {
+	f.Lock()
+	defer f.Unlock()
+	f.cb = cb
+}
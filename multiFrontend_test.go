@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"github.com/racerxdl/radioserver/StateModels"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// addTestFrontend adds a named mockFrontend to serverStates, like a second entry in Frontends
+func addTestFrontend(name string) (*StateModels.ServerState, *mockFrontend) {
+	var frontend = createMockFrontend()
+	var s = StateModels.CreateServerState()
+	s.Name = name
+	s.Frontend = frontend
+	frontend.SetSamplesAvailableCallback(s.PushSamples)
+	s.OpenFrontend(context.Background(), nil, 0)
+	s.SetControlMode(StateModels.ControlModeFirstCome, 0)
+	serverStates = append(serverStates, s)
+
+	return s, frontend
+}
+
+func createDiscardClientAt(s *StateModels.ServerState) (*StateModels.ClientState, func()) {
+	server, client := net.Pipe()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, client)
+	}()
+
+	var state = createClientState(server, s)
+	s.PushClient(state)
+
+	return state, func() {
+		state.FullStop()
+		s.RemoveClient(state)
+		_ = server.Close()
+		_ = client.Close()
+	}
+}
+
+func TestMultipleFrontends(t *testing.T) {
+	var vhfFrontend = setupTestServer()
+	serverState.Name = "vhf"
+	var uhf, uhfFrontend = addTestFrontend("uhf")
+
+	var l = &serverListener{
+		Listener: pipeListener{},
+		config:   &ListenerConfig{Frontend: "uhf"},
+	}
+	if l.serverState() != uhf {
+		t.Fatalf("expected the listener to serve the uhf frontend")
+	}
+
+	if findServerState("") != serverState || findServerState("vhf") != serverState || findServerState("shf") != nil {
+		t.Errorf("findServerState returned the wrong frontend")
+	}
+
+	vhfClient, vhfClose := createDiscardClient()
+	defer vhfClose()
+	uhfClient, uhfClose := createDiscardClientAt(uhf)
+
+	if !vhfFrontend.running || !uhfFrontend.running {
+		t.Errorf("expected both frontends running (vhf %t, uhf %t)", vhfFrontend.running, uhfFrontend.running)
+	}
+
+	// Each frontend has its own control owner
+	if !serverState.HasControl(vhfClient) || !uhf.HasControl(uhfClient) {
+		t.Errorf("expected each client to have control of its frontend")
+	}
+
+	if len(serverState.GetClients()) != 1 || len(uhf.GetClients()) != 1 {
+		t.Errorf("expected one client per frontend got vhf %d uhf %d", len(serverState.GetClients()), len(uhf.GetClients()))
+	}
+
+	if !uhfClient.SetDeviceFrequency(433000000) {
+		t.Fatalf("expected the uhf frequency to be set")
+	}
+
+	if uhfFrontend.centerFrequency != 433000000 || vhfFrontend.centerFrequency != mockCenterFrequency {
+		t.Errorf("expected only the uhf frontend to be tuned (vhf %d, uhf %d)", vhfFrontend.centerFrequency, uhfFrontend.centerFrequency)
+	}
+
+	uhfClose()
+
+	if uhfFrontend.running || !vhfFrontend.running {
+		t.Errorf("expected only the uhf frontend to stop (vhf %t, uhf %t)", vhfFrontend.running, uhfFrontend.running)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/racerxdl/radioserver/protocol"
+	"strings"
 )
 
 var ServerVersion = protocol.Version{
@@ -12,3 +14,36 @@ var ServerVersion = protocol.Version{
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+var configFile = flag.String("config", "", "server configuration file (json)")
+var logLevel = flag.String("log-level", "", "minimum log level: debug, info, warn or error (overrides the config)")
+var logScopes = flag.String("log-scopes", "", "log level of some scopes, for example \"Client=warn,TCP Server=debug\" (added to the config ones)")
+var logFormat = flag.String("log-format", "", "log format: auto, text, plain or json (overrides the config)")
+
+// applyLogFlags overrides the log config with the command line flags
+func applyLogFlags(config *LogConfig) error {
+	if *logLevel != "" {
+		config.Level = *logLevel
+	}
+
+	if *logFormat != "" {
+		config.Format = *logFormat
+	}
+
+	if *logScopes == "" {
+		return nil
+	}
+
+	if config.Scopes == nil {
+		config.Scopes = map[string]string{}
+	}
+
+	for _, v := range strings.Split(*logScopes, ",") {
+		var parts = strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid log scope %q, expected scope=level", v)
+		}
+		config.Scopes[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return nil
+}
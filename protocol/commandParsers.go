@@ -3,33 +3,112 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
-func ParseCmdHelloBody(data []uint8) (Version, string) {
+// MaxClientNameSize is the maximum size of the client name sent in CmdHello
+const MaxClientNameSize = 1024
+
+// MaxSettingArgs is the maximum number of arguments in a CmdSetSetting
+const MaxSettingArgs = 16
+
+// CommandError is returned when a command sent by the client is malformed
+type CommandError struct {
+	CommandType uint32
+	BodySize    uint32
+	Reason      string
+}
+
+func (e *CommandError) Error() string {
+	var name, ok = CommandNames[e.CommandType]
+	if !ok {
+		name = fmt.Sprintf("Command %d", e.CommandType)
+	}
+
+	return fmt.Sprintf("%s with %d bytes body: %s", name, e.BodySize, e.Reason)
+}
+
+func commandError(commandType uint32, bodySize int, format string, v ...interface{}) *CommandError {
+	return &CommandError{
+		CommandType: commandType,
+		BodySize:    uint32(bodySize),
+		Reason:      fmt.Sprintf(format, v...),
+	}
+}
+
+// ValidateCommandHeader checks if the command type is known and the body size is valid for it
+func ValidateCommandHeader(header CommandHeader) error {
+	var bodySize = int(header.BodySize)
+
+	switch header.CommandType {
+	case CmdHello:
+		if bodySize < 4 || bodySize > 4+MaxClientNameSize {
+			return commandError(header.CommandType, bodySize, "expected between 4 and %d bytes", 4+MaxClientNameSize)
+		}
+	case CmdGetSetting:
+		if bodySize < 4 || bodySize > 4*(1+MaxSettingArgs) || bodySize%4 != 0 {
+			return commandError(header.CommandType, bodySize, "expected a multiple of 4 between 4 and %d bytes", 4*(1+MaxSettingArgs))
+		}
+	case CmdSetSetting:
+		if bodySize < 8 || bodySize > 4*(1+MaxSettingArgs) || bodySize%4 != 0 {
+			return commandError(header.CommandType, bodySize, "expected a multiple of 4 between 8 and %d bytes", 4*(1+MaxSettingArgs))
+		}
+	case CmdPing:
+		if bodySize != 8 {
+			return commandError(header.CommandType, bodySize, "expected 8 bytes")
+		}
+	default:
+		return commandError(header.CommandType, bodySize, "unknown command")
+	}
+
+	return nil
+}
+
+func ParseCmdHelloBody(data []uint8) (Version, string, error) {
 	var protocolVersion uint32
 	var clientName string
+
+	if len(data) < 4 {
+		return Version{}, "", commandError(CmdHello, len(data), "body too short")
+	}
+
 	buf := bytes.NewReader(data)
 	_ = binary.Read(buf, binary.LittleEndian, &protocolVersion)
 
 	clientName = string(data[4:])
 
-	return SplitProtocolVersion(protocolVersion), clientName
+	return SplitProtocolVersion(protocolVersion), clientName, nil
 }
 
-func ParseCmdGetSettingBody(data []uint8) {
-	// TODO: Implement-me
+// ParseCmdGetSettingBody returns the setting to be read. Extra arguments are ignored.
+func ParseCmdGetSettingBody(data []uint8) (setting uint32, err error) {
+	if len(data) < 4 {
+		return 0, commandError(CmdGetSetting, len(data), "expected a setting")
+	}
+
+	setting = binary.LittleEndian.Uint32(data)
+
+	return setting, nil
 }
 
-func ParseCmdPingBody(data []uint8) int64 {
+func ParseCmdPingBody(data []uint8) (int64, error) {
 	var timestamp int64
 
+	if len(data) != 8 {
+		return 0, commandError(CmdPing, len(data), "expected 8 bytes")
+	}
+
 	buf := bytes.NewReader(data)
 	_ = binary.Read(buf, binary.LittleEndian, &timestamp)
 
-	return timestamp
+	return timestamp, nil
 }
 
-func ParseCmdSetSettingBody(data []uint8) (setting uint32, args []uint32) {
+func ParseCmdSetSettingBody(data []uint8) (setting uint32, args []uint32, err error) {
+	if len(data) < 8 || len(data)%4 != 0 {
+		return 0, nil, commandError(CmdSetSetting, len(data), "expected a setting and at least one argument")
+	}
+
 	buf := bytes.NewReader(data)
 
 	var numArgs = (len(data) - 4) / 4
@@ -41,5 +120,5 @@ func ParseCmdSetSettingBody(data []uint8) (setting uint32, args []uint32) {
 		_ = binary.Read(buf, binary.LittleEndian, &args[i])
 	}
 
-	return setting, args
+	return setting, args, nil
 }
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestValidateCommandHeader(t *testing.T) {
+	var cases = []struct {
+		header CommandHeader
+		valid  bool
+	}{
+		{CommandHeader{CmdHello, 4}, true},
+		{CommandHeader{CmdHello, 20}, true},
+		{CommandHeader{CmdHello, 3}, false},
+		{CommandHeader{CmdHello, 4 + MaxClientNameSize + 1}, false},
+		{CommandHeader{CmdSetSetting, 8}, true},
+		{CommandHeader{CmdSetSetting, 4}, false},
+		{CommandHeader{CmdSetSetting, 10}, false},
+		{CommandHeader{CmdSetSetting, 4 * (2 + MaxSettingArgs)}, false},
+		{CommandHeader{CmdGetSetting, 4}, true},
+		{CommandHeader{CmdPing, 8}, true},
+		{CommandHeader{CmdPing, 0}, false},
+		{CommandHeader{CmdPing, 9}, false},
+		{CommandHeader{1234, 8}, false},
+	}
+
+	for _, c := range cases {
+		err := ValidateCommandHeader(c.header)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateCommandHeader(%+v) = %v, expected valid = %t", c.header, err, c.valid)
+		}
+	}
+}
+
+func TestParseCmdSetSettingBody(t *testing.T) {
+	var data = make([]uint8, 12)
+	binary.LittleEndian.PutUint32(data[0:], SettingIqFrequency)
+	binary.LittleEndian.PutUint32(data[4:], 106300000)
+	binary.LittleEndian.PutUint32(data[8:], 1)
+
+	setting, args, err := ParseCmdSetSettingBody(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if setting != SettingIqFrequency || len(args) != 2 || args[0] != 106300000 || args[1] != 1 {
+		t.Errorf("got setting %d args %v", setting, args)
+	}
+
+	_, _, err = ParseCmdSetSettingBody(data[:4])
+	if err == nil {
+		t.Errorf("expected error for a setting without arguments")
+	}
+}
+
+func TestParseCmdGetSettingBody(t *testing.T) {
+	var data = make([]uint8, 4)
+	binary.LittleEndian.PutUint32(data, SettingDeviceAntenna)
+
+	setting, err := ParseCmdGetSettingBody(data)
+	if err != nil || setting != SettingDeviceAntenna {
+		t.Errorf("got setting %d (%v)", setting, err)
+	}
+
+	_, err = ParseCmdGetSettingBody(data[:2])
+	if err == nil {
+		t.Errorf("expected error for a short body")
+	}
+}
+
+func FuzzParseCmdHelloBody(f *testing.F) {
+	f.Add([]uint8{})
+	f.Add([]uint8{0x00, 0x00, 0x00, 0x02})
+	f.Add(append([]uint8{0xa4, 0x06, 0x00, 0x02}, []uint8("SDR#")...))
+
+	f.Fuzz(func(t *testing.T, data []uint8) {
+		_, name, err := ParseCmdHelloBody(data)
+		if len(data) < 4 {
+			if err == nil {
+				t.Errorf("expected error for %d bytes", len(data))
+			}
+			return
+		}
+
+		if err != nil || len(name) != len(data)-4 {
+			t.Errorf("unexpected result for %d bytes: %q %v", len(data), name, err)
+		}
+	})
+}
+
+func FuzzParseCmdPingBody(f *testing.F) {
+	f.Add([]uint8{})
+	f.Add([]uint8{1, 2, 3, 4, 5, 6, 7, 8})
+
+	f.Fuzz(func(t *testing.T, data []uint8) {
+		_, err := ParseCmdPingBody(data)
+		if (err == nil) != (len(data) == 8) {
+			t.Errorf("unexpected error for %d bytes: %v", len(data), err)
+		}
+	})
+}
+
+func FuzzParseCmdSetSettingBody(f *testing.F) {
+	f.Add([]uint8{})
+	f.Add([]uint8{1, 0, 0, 0})
+	f.Add([]uint8{1, 0, 0, 0, 1, 0, 0, 0})
+	f.Add([]uint8{1, 0, 0, 0, 1, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []uint8) {
+		_, args, err := ParseCmdSetSettingBody(data)
+		if err != nil {
+			return
+		}
+
+		if len(args) == 0 || len(args) != (len(data)-4)/4 {
+			t.Errorf("got %d args for %d bytes", len(args), len(data))
+		}
+	})
+}
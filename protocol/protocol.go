@@ -108,6 +108,14 @@ const (
 	CmdPing       = 3
 )
 
+// CommandNames list of command names by their ids
+var CommandNames = map[uint32]string{
+	CmdHello:      "Hello",
+	CmdGetSetting: "Get Setting",
+	CmdSetSetting: "Set Setting",
+	CmdPing:       "Ping",
+}
+
 const (
 	SettingStreamingMode    = 0
 	SettingStreamingEnabled = 1
@@ -122,6 +130,14 @@ const (
 	SettingFFTDbOffset      = 203
 	SettingFFTDbRange       = 204
 	SettingFFTDisplayPixels = 205
+
+	// Radio Server Standard
+	SettingDeviceFrequency  = 100000
+	SettingDeviceChannel    = 100001
+	SettingDeviceAntenna    = 100002
+	SettingDeviceAGC        = 100003
+	SettingDeviceBiasT      = 100004
+	SettingDeviceSampleRate = 100005
 )
 
 // SettingNames list of device names by their ids
@@ -139,6 +155,12 @@ var SettingNames = map[uint32]string{
 	SettingFFTDbOffset:      "FFT dB Offset",
 	SettingFFTDbRange:       "FFT dB Range",
 	SettingFFTDisplayPixels: "FFT Display Pixels",
+	SettingDeviceFrequency:  "Device Frequency",
+	SettingDeviceChannel:    "Device Channel",
+	SettingDeviceAntenna:    "Device Antenna",
+	SettingDeviceAGC:        "Device AGC",
+	SettingDeviceBiasT:      "Device Bias-T",
+	SettingDeviceSampleRate: "Device Sample Rate",
 }
 
 var PossibleSettings = []uint32{
@@ -156,10 +178,41 @@ var PossibleSettings = []uint32{
 	SettingFFTDbOffset,
 	SettingFFTDbRange,
 	SettingFFTDisplayPixels,
+
+	SettingDeviceFrequency,
+	SettingDeviceChannel,
+	SettingDeviceAntenna,
+	SettingDeviceAGC,
+	SettingDeviceBiasT,
+	SettingDeviceSampleRate,
 }
 
 var GlobalAffectedSettings = []uint32{
 	SettingGain,
+	SettingDeviceFrequency,
+	SettingDeviceChannel,
+	SettingDeviceAntenna,
+	SettingDeviceAGC,
+	SettingDeviceBiasT,
+	SettingDeviceSampleRate,
+}
+
+// ReadableSettings are the settings that can be read with CmdGetSetting. The value is sent in a MsgTypeReadSetting.
+var ReadableSettings = []uint32{
+	SettingDeviceChannel,
+	SettingDeviceAntenna,
+	SettingDeviceAGC,
+	SettingDeviceBiasT,
+	SettingDeviceSampleRate,
+}
+
+// SyncAffectedSettings are settings that change the values reported in ClientSync
+var SyncAffectedSettings = []uint32{
+	SettingIqFormat,
+	SettingIqFrequency,
+	SettingIqDecimation,
+	SettingFFTFrequency,
+	SettingFFTDecimation,
 }
 
 func IsSettingPossible(setting uint32) bool {
@@ -172,6 +225,16 @@ func IsSettingPossible(setting uint32) bool {
 	return false
 }
 
+func IsSettingReadable(setting uint32) bool {
+	for _, v := range ReadableSettings {
+		if setting == v {
+			return true
+		}
+	}
+
+	return false
+}
+
 func SettingAffectsGlobal(setting uint32) bool {
 	for _, v := range GlobalAffectedSettings {
 		if setting == v {
@@ -182,6 +245,16 @@ func SettingAffectsGlobal(setting uint32) bool {
 	return false
 }
 
+func SettingAffectsSync(setting uint32) bool {
+	for _, v := range SyncAffectedSettings {
+		if setting == v {
+			return true
+		}
+	}
+
+	return false
+}
+
 // StreamTypes is a enum that defines which stream types the spyserver supports.
 const (
 	StreamTypeStatus = 0
@@ -229,6 +302,23 @@ const (
 	MsgTypeFloatAF     = 203
 	MsgTypeDint4FFT    = 300
 	MsgTypeUint8FFT    = 301
+
+	// Radio Server Standard
+	MsgTypeServerMessage = 100000
+)
+
+// ServerMessage codes, sent in the body of MsgTypeServerMessage followed by an UTF-8 text
+const (
+	ServerMessageInfo         = 0
+	ServerMessageRejected     = 1
+	ServerMessageDisconnected = 2
+	// ServerMessageFrontendLost is sent when the frontend stops sending samples, while it is re-opened
+	ServerMessageFrontendLost = 3
+	// ServerMessageFrontendRecovered is sent after the frontend works again, followed by a DeviceInfo and ClientSync
+	ServerMessageFrontendRecovered = 4
+	// ServerMessageDeviceError is sent when the frontend fails to apply a device setting, with the error. It is
+	// followed by a ClientSync with the values in use.
+	ServerMessageDeviceError = 5
 )
 
 type MessageHeader struct {
@@ -275,6 +365,18 @@ type PingPacket struct {
 	Timestamp int64
 }
 
+type ServerMessage struct {
+	Code uint32
+}
+
+// ReadSetting is the body of MsgTypeReadSetting, followed by an UTF-8 text. For SettingDeviceAntenna the value is
+// the index of the selected antenna and the text has the available antenna names, one per line. For
+// SettingDeviceSampleRate the value is the current sample rate and the text has the available ones, one per line.
+type ReadSetting struct {
+	Setting uint32
+	Value   uint32
+}
+
 const MessageHeaderSize = uint32(unsafe.Sizeof(MessageHeader{}))
 const CommandHeaderSize = uint32(unsafe.Sizeof(CommandHeader{}))
 const MaxMessageBodySize = 1 << 20
@@ -1,19 +1,111 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/racerxdl/radioserver/SLog"
+	"github.com/racerxdl/radioserver/StateModels"
 	"github.com/racerxdl/radioserver/frontends"
-	"github.com/racerxdl/radioserver/protocol"
 	"github.com/racerxdl/segdsp/dsp"
 	"os"
 	"os/signal"
 	"runtime/debug"
 	"runtime/pprof"
+	"strings"
 	"syscall"
+	"time"
 )
 
+func createFrontend(config FrontendConfig) (frontends.Frontend, error) {
+	var frontendType = config.Type
+	if frontendType == "" {
+		var err error
+		frontendType, err = frontends.DefaultFrontend()
+		if err != nil {
+			return nil, err
+		}
+		SLog.Info("No frontend Type configured, using %s", frontendType)
+	}
+
+	frontend, err := frontends.CreateFrontend(frontendType, frontends.FrontendOptions{
+		DeviceIndex: config.DeviceIndex,
+		Channel:     config.Channel,
+		Address:     config.Address,
+		IQFormat:    iqFormats[config.IQFormat],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var correction = frontends.CorrectionOptions{
+		PPM:       config.Correction.PPM,
+		RemoveDC:  config.Correction.RemoveDC,
+		CorrectIQ: config.Correction.CorrectIQ,
+	}
+	if correction.Enabled() {
+		return frontends.CreateCorrectionFrontend(frontend, correction), nil
+	}
+
+	return frontend, nil
+}
+
+// configureFrontend applies the configured antenna, sample rate and frequency after the frontend is opened
+func configureFrontend(config FrontendConfig) StateModels.FrontendSetup {
+	return func(frontend frontends.Frontend) error {
+		if config.Antenna != "" {
+			err := frontend.SetAntenna(config.Antenna)
+			if err != nil {
+				return fmt.Errorf("error setting antenna %s: %s", config.Antenna, err)
+			}
+		}
+
+		if config.SampleRate != 0 {
+			_, err := frontend.SetSampleRate(config.SampleRate)
+			if err != nil {
+				return fmt.Errorf("error setting sample rate %d: %s", config.SampleRate, err)
+			}
+		}
+
+		if config.CenterFrequency != 0 {
+			_, err := frontend.SetCenterFrequency(config.CenterFrequency)
+			if err != nil {
+				return fmt.Errorf("error setting frequency %d: %s", config.CenterFrequency, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// openFrontend creates a frontend with its own ServerState and opens it. If the device is not available the server
+// starts anyway and keeps trying to open it until ctx is done.
+func openFrontend(ctx context.Context, config FrontendConfig) *StateModels.ServerState {
+	frontend, err := createFrontend(config)
+	if err != nil {
+		SLog.Fatal("Error creating frontend %s: %s", config.Name, err)
+	}
+
+	var state = StateModels.CreateServerState()
+	state.Name = config.Name
+	state.Frontend = frontend
+	frontend.SetSamplesAvailableCallback(state.PushSamples)
+
+	var retryInterval = time.Duration(config.RetrySeconds) * time.Second
+	if !state.OpenFrontend(ctx, configureFrontend(config), retryInterval) {
+		SLog.Warn("Frontend %s is not available, starting without it", frontend.GetShortName())
+		return state
+	}
+
+	if config.Name != "" {
+		SLog.Info("Frontend %s: %s", config.Name, frontend.GetName())
+	} else {
+		SLog.Info("Frontend: %s", frontend.GetName())
+	}
+
+	return state
+}
+
 func main() {
 	flag.Parse()
 	if *cpuprofile != "" {
@@ -33,38 +125,38 @@ func main() {
 		}
 	}()
 
+	if *configFile != "" {
+		config, err := LoadServerConfig(*configFile)
+		if err != nil {
+			SLog.Fatal("Error loading config: %s", err)
+		}
+		serverConfig = config
+	}
+
+	err := applyLogFlags(&serverConfig.Log)
+	if err != nil {
+		SLog.Fatal("Error in the log flags: %s", err)
+	}
+
+	closeLogs, err := serverConfig.Log.Apply()
+	if err != nil {
+		SLog.Fatal("Error opening the logs: %s", err)
+	}
+	defer closeLogs()
+
 	SLog.Info("Protocol Version: %s", ServerVersion.String())
 	SLog.Info("Commit Hash: %s", commitHash)
 	SLog.Info("SIMD Mode: %s", dsp.GetSIMDMode())
 
-	var frontend = frontends.CreateAirspyFrontend(0)
-	//var frontend = frontends.CreateLimeSDRFrontend(0)
-	frontend.Init()
-	frontend.SetCenterFrequency(106300000)
-
-	defer frontend.Destroy()
-
-	SLog.Info("Frontend: %s", frontend.GetName())
-
-	serverState.Frontend = frontend
-	serverState.CanControl = 0
-
-	serverState.DeviceInfo = protocol.DeviceInfo{
-		DeviceType:           frontend.GetDeviceType(),
-		DeviceSerial:         frontend.GetUintDeviceSerial(),
-		MaximumSampleRate:    frontend.GetMaximumSampleRate(),
-		MaximumBandwidth:     frontend.GetMaximumBandwidth(),
-		DecimationStageCount: frontend.MaximumDecimationStages(),
-		GainStageCount:       frontend.MaximumGainIndex(),
-		MaximumGainIndex:     0,
-		MinimumFrequency:     frontend.MinimumFrequency(),
-		MaximumFrequency:     frontend.MaximumFrequency(),
-		MinimumIQDecimation:  0,
-		Resolution:           0,
-		ForcedIQFormat:       protocol.StreamFormatFloat,
+	SLog.Info("Available Frontends: %s", strings.Join(frontends.AvailableFrontends(), ", "))
+
+	controlMode, ok := StateModels.ParseControlMode(serverConfig.Control.Mode)
+	if !ok {
+		SLog.Fatal("Invalid control mode: %s", serverConfig.Control.Mode)
 	}
 
-	frontend.SetSamplesAvailableCallback(serverState.PushSamples)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	stop := make(chan bool, 1)
 	c := make(chan os.Signal, 1)
@@ -74,9 +166,25 @@ func main() {
 		<-c
 		SLog.Info("Got SIGTERM! Closing it")
 		tcpServerStatus = false
+		cancel()
 		stop <- true
 	}()
 
+	serverStates = make([]*StateModels.ServerState, 0)
+	for _, config := range serverConfig.GetFrontends() {
+		var state = openFrontend(ctx, config)
+		state.SetControlMode(controlMode, time.Duration(serverConfig.Control.LeaseSeconds)*time.Second)
+		state.StartWatchdog(ctx, time.Duration(config.WatchdogSeconds)*time.Second)
+		defer state.Frontend.Destroy()
+
+		serverStates = append(serverStates, state)
+	}
+	serverState = serverStates[0]
+
+	if serverConfig.Admin.Address != "" {
+		go runAdminServer(serverConfig.Admin)
+	}
+
 	// frontend.Start()
 	// defer frontend.Stop()
 	runServer(stop)
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/protocol"
+	"github.com/racerxdl/radioserver/tools"
+	"net"
+	"time"
+)
+
+const rtlTcpCommandSize = 5
+
+// rtlTcpMaximumGain is the highest R820T gain in tenths of dB. rtl_tcp clients send gains from the R820T table since
+// that is the tuner we report, they're scaled to our gain indexes.
+const rtlTcpMaximumGain = 496
+
+// rtlTcpDecimation returns the decimation stage whose output sample rate is the closest to sampleRate
+func rtlTcpDecimation(s *StateModels.ServerState, sampleRate uint32) uint32 {
+	var deviceSampleRate = s.DeviceSampleRate()
+	var bestDecimation = uint32(0)
+	var bestDifference = ^uint32(0)
+	var stageCount = s.GetDeviceInfo().DecimationStageCount
+
+	for decimation := uint32(0); decimation <= stageCount; decimation++ {
+		var channelSampleRate = deviceSampleRate / tools.StageToNumber(decimation)
+		var difference = channelSampleRate - sampleRate
+		if sampleRate > channelSampleRate {
+			difference = sampleRate - channelSampleRate
+		}
+
+		if difference < bestDifference {
+			bestDecimation = decimation
+			bestDifference = difference
+		}
+	}
+
+	return bestDecimation
+}
+
+func rtlTcpSetGain(state *StateModels.ClientState, gainIndex uint32) {
+	if !state.ServerState.HasControl(state) {
+		state.Warn("Ignoring gain change: client does not have control")
+		return
+	}
+
+	var gainStageCount = state.ServerState.GetDeviceInfo().GainStageCount
+	if gainIndex > gainStageCount {
+		gainIndex = gainStageCount
+	}
+
+	var oldGain = uint32(state.ServerState.DeviceGain())
+	if state.SetGain(gainIndex) {
+		auditSetting(state, protocol.SettingGain, gainIndex, oldGain, "")
+	} else {
+		auditSetting(state, protocol.SettingGain, gainIndex, oldGain, "rejected")
+	}
+	state.ServerState.SendSync()
+}
+
+func runRtlTcpCommand(state *StateModels.ClientState, command uint8, parameter uint32) {
+	state.LastCommandTime = time.Now()
+	state.CmdReceived++
+
+	switch command {
+	case frontends.RtlTcpCmdSetFrequency:
+		// rtl_tcp clients expect to tune the device, so retune it if the channel would fall outside and we can
+		var syncInfo = state.GetSyncInfo()
+		var outsideWindow = parameter < syncInfo.MinimumIQCenterFrequency || parameter > syncInfo.MaximumIQCenterFrequency
+		if outsideWindow && state.ServerState.HasControl(state) {
+			var oldFrequency = state.ServerState.DeviceCenterFrequency()
+			if state.SetDeviceFrequency(parameter) {
+				auditSetting(state, protocol.SettingDeviceFrequency, parameter, oldFrequency, "")
+				state.ServerState.SendSync()
+			} else {
+				auditSetting(state, protocol.SettingDeviceFrequency, parameter, oldFrequency, "rejected")
+			}
+		}
+		state.SetIQFrequency(parameter)
+	case frontends.RtlTcpCmdSetSampleRate:
+		var decimation = rtlTcpDecimation(state.ServerState, parameter)
+		if !state.SetIQDecimation(decimation) {
+			state.Warn("Cannot set sample rate %d", parameter)
+			return
+		}
+		var sampleRate = state.ServerState.DeviceSampleRate() / tools.StageToNumber(state.GetCGS().IQDecimation)
+		if sampleRate != parameter {
+			state.Warn("Sample rate %d is not available. Using %d", parameter, sampleRate)
+		}
+	case frontends.RtlTcpCmdSetGain:
+		rtlTcpSetGain(state, parameter*state.ServerState.GetDeviceInfo().GainStageCount/rtlTcpMaximumGain)
+		return
+	case frontends.RtlTcpCmdSetGainByIndex:
+		rtlTcpSetGain(state, parameter)
+		return
+	default:
+		state.Debug("Ignoring rtl_tcp command %d(%d)", command, parameter)
+		return
+	}
+
+	state.SendSync()
+	state.CG.UpdateSettings(state)
+}
+
+// handleRtlTcpConnection serves a single channel to a rtl_tcp client. The channel is streamed as uint8 IQ without any
+// framing and the commands change the channel frequency / decimation instead of the device.
+func handleRtlTcpConnection(c net.Conn, l *serverListener) {
+	var clientState = createClientState(c, l.serverState())
+	clientState.Name = "rtl_tcp"
+	clientState.RawOutput = true
+	clientState.Bandwidth = l.bandwidthLimits()
+	clientState.Timeouts = serverConfig.Timeouts.ClientTimeouts()
+
+	defer func() {
+		l.release(remoteIP(c.RemoteAddr()), clientState.ProtocolErrors)
+	}()
+
+	tcpSlog.Log("New rtl_tcp connection from %s at %s", clientState.Addr, l.Addr())
+
+	var header = frontends.CreateRtlTcpHeader(frontends.RtlSdrTunerR820T, clientState.ServerState.GetDeviceInfo().GainStageCount+1)
+	if !clientState.SendData(header) {
+		c.Close()
+		return
+	}
+
+	clientState.ServerState.PushClient(clientState)
+	auditConnect(clientState, l)
+
+	clientState.SetStreamingMode(protocol.StreamModeIQOnly)
+	clientState.SetIQFormat(protocol.StreamFormatUint8)
+	clientState.SetIQDecimation(l.config.RtlTcp.Decimation)
+	if l.config.RtlTcp.Frequency != 0 {
+		clientState.SetIQFrequency(l.config.RtlTcp.Frequency)
+	}
+
+	if clientState.SetStreamingEnabled(true) {
+		clientState.SendSync()
+		clientState.CG.UpdateSettings(clientState)
+	} else {
+		clientState.Disconnect("bandwidth limits exceeded")
+	}
+
+	var command = make([]uint8, rtlTcpCommandSize)
+	var position = 0
+
+	for {
+		if !tcpServerStatus {
+			clientState.Disconnect("server shutting down")
+		}
+
+		if !clientState.IsRunning() || !clientState.CheckTimeouts() {
+			break
+		}
+
+		_ = c.SetReadDeadline(time.Now().Add(defaultReadTimeout))
+		n, err := c.Read(command[position:])
+
+		if err != nil {
+			parseHttpError(err, clientState)
+		}
+
+		clientState.AddReceivedBytes(n)
+		position += n
+
+		if position == rtlTcpCommandSize {
+			runRtlTcpCommand(clientState, command[0], binary.BigEndian.Uint32(command[1:]))
+			position = 0
+		}
+	}
+
+	clientState.FullStop()
+	clientState.ServerState.RemoveClient(clientState)
+	tcpSlog.Log("rtl_tcp connection closed from %s: %s", clientState.Addr, clientState.GetDisconnectReason())
+	auditDisconnect(clientState)
+	c.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/tools"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type rtlTcpTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	done chan bool
+}
+
+func startRtlTcpClient(t *testing.T, config RtlTcpConfig) (*rtlTcpTestClient, *mockFrontend) {
+	var frontend = setupTestServer()
+	globalGuard, _ = createConnectionGuard(&LimitsConfig{})
+
+	var l = &serverListener{
+		Listener: pipeListener{},
+		config:   &ListenerConfig{Protocol: "rtltcp", RtlTcp: config},
+	}
+	l.guard, _ = createConnectionGuard(&l.config.LimitsConfig)
+
+	server, client := net.Pipe()
+	_, _ = l.admit(remoteIP(server.RemoteAddr()))
+
+	var rc = &rtlTcpTestClient{
+		t:    t,
+		conn: client,
+		done: make(chan bool),
+	}
+
+	go func() {
+		handleRtlTcpConnection(server, l)
+		close(rc.done)
+	}()
+
+	return rc, frontend
+}
+
+func (rc *rtlTcpTestClient) read(size int) []uint8 {
+	var data = make([]uint8, size)
+	_ = rc.conn.SetReadDeadline(time.Now().Add(conformanceReadTimeout))
+	_, err := io.ReadFull(rc.conn, data)
+	if err != nil {
+		rc.t.Fatalf("error reading %d bytes: %s", size, err)
+	}
+
+	return data
+}
+
+func (rc *rtlTcpTestClient) sendCommand(command uint8, parameter uint32) {
+	var data = make([]uint8, rtlTcpCommandSize)
+	data[0] = command
+	binary.BigEndian.PutUint32(data[1:], parameter)
+
+	_, err := rc.conn.Write(data)
+	if err != nil {
+		rc.t.Fatalf("error sending command: %s", err)
+	}
+}
+
+// waitClient waits for the rtl_tcp client to be streaming and for check to be true
+func (rc *rtlTcpTestClient) waitClient(check func(state *StateModels.ClientState) bool) *StateModels.ClientState {
+	var deadline = time.Now().Add(conformanceReadTimeout)
+	for time.Now().Before(deadline) {
+		var clients = serverState.GetClients()
+		if len(clients) > 0 && clients[0].GetCGS().Streaming && check(clients[0]) {
+			return clients[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rc.t.Fatalf("client did not reach the expected state")
+	return nil
+}
+
+func (rc *rtlTcpTestClient) close() {
+	_ = rc.conn.Close()
+
+	select {
+	case <-rc.done:
+	case <-time.After(conformanceReadTimeout):
+		rc.t.Fatalf("handleRtlTcpConnection did not return after the client closed the connection")
+	}
+}
+
+func TestRtlTcpServerStream(t *testing.T) {
+	var rc, frontend = startRtlTcpClient(t, RtlTcpConfig{})
+	defer rc.close()
+
+	tunerType, gainCount, err := frontends.ParseRtlTcpHeader(rc.read(frontends.RtlTcpHeaderSize))
+	if err != nil {
+		t.Fatalf("error parsing header: %s", err)
+	}
+
+	if tunerType != frontends.RtlSdrTunerR820T || gainCount != serverState.GetDeviceInfo().GainStageCount+1 {
+		t.Fatalf("unexpected header: tuner %d with %d gains", tunerType, gainCount)
+	}
+
+	rc.waitClient(func(state *StateModels.ClientState) bool { return true })
+
+	var samples = []complex64{complex(0, 0), complex(0.5, -0.5), complex(-1, 1)}
+	frontend.pushSamples(samples)
+
+	var expected = tools.Complex64ToUInt8(samples)
+	var received = rc.read(len(expected))
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected raw samples %v got %v", expected, received)
+		}
+	}
+}
+
+func TestRtlTcpServerCommands(t *testing.T) {
+	var rc, _ = startRtlTcpClient(t, RtlTcpConfig{Decimation: 2})
+	defer rc.close()
+
+	// Keep reading so the server never blocks sending samples
+	rc.read(frontends.RtlTcpHeaderSize)
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, rc.conn)
+	}()
+
+	rc.waitClient(func(state *StateModels.ClientState) bool { return state.GetCGS().IQDecimation == 2 })
+
+	rc.sendCommand(frontends.RtlTcpCmdSetFrequency, mockCenterFrequency+100000)
+	rc.waitClient(func(state *StateModels.ClientState) bool {
+		return state.GetCGS().IQCenterFrequency == mockCenterFrequency+100000
+	})
+
+	rc.sendCommand(frontends.RtlTcpCmdSetSampleRate, mockSampleRate/8)
+	rc.waitClient(func(state *StateModels.ClientState) bool { return state.GetCGS().IQDecimation == 3 })
+
+	// Outside of the window: the client has control, so the device is retuned
+	rc.sendCommand(frontends.RtlTcpCmdSetFrequency, 433920000)
+	rc.waitClient(func(state *StateModels.ClientState) bool {
+		return state.GetCGS().IQCenterFrequency == 433920000 && serverState.DeviceCenterFrequency() == 433920000
+	})
+
+	rc.sendCommand(frontends.RtlTcpCmdSetGainByIndex, 5)
+	rc.waitClient(func(state *StateModels.ClientState) bool { return serverState.DeviceGain() == 5 })
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/racerxdl/radioserver/protocol"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var updateSnapshots = flag.Bool("update", false, "update the recorded responses in testdata/snapshots")
+
+// parseCommandLine converts a line of a .script file into the bytes a client sends. Supported lines:
+//
+//	hello <major.minor.revision> <client name>
+//	set <setting id> <args...>
+//
+// Everything after # is a comment.
+func parseCommandLine(line string) ([]uint8, error) {
+	if idx := strings.Index(line, "#"); idx != -1 {
+		line = line[:idx]
+	}
+
+	var fields = strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	switch fields[0] {
+	case "hello":
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("hello needs a version and a name")
+		}
+		var version protocol.Version
+		_, err := fmt.Sscanf(fields[1], "%d.%d.%d", &version.Major, &version.Minor, &version.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", fields[1])
+		}
+		var name = strings.TrimSpace(strings.SplitN(line, fields[1], 2)[1])
+		var body = make([]uint8, 4)
+		binary.LittleEndian.PutUint32(body, version.ToUint32())
+		return makeCommand(protocol.CmdHello, append(body, []uint8(name)...)), nil
+	case "set":
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("set needs a setting and at least one argument")
+		}
+		var values = make([]uint32, len(fields)-1)
+		for i, v := range fields[1:] {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", v)
+			}
+			values[i] = uint32(n)
+		}
+		return makeSetSetting(values[0], values[1:]...), nil
+	}
+
+	return nil, fmt.Errorf("unknown command %q", fields[0])
+}
+
+func formatServerMessage(header protocol.MessageHeader, body []uint8) string {
+	var description string
+	var reader = bytes.NewReader(body)
+
+	switch header.MessageType {
+	case protocol.MsgTypeDeviceInfo:
+		var deviceInfo protocol.DeviceInfo
+		_ = binary.Read(reader, binary.LittleEndian, &deviceInfo)
+		description = fmt.Sprintf("DeviceInfo %+v", deviceInfo)
+	case protocol.MsgTypeClientSync:
+		var clientSync protocol.ClientSync
+		_ = binary.Read(reader, binary.LittleEndian, &clientSync)
+		description = fmt.Sprintf("ClientSync %+v", clientSync)
+	case protocol.MsgTypeServerMessage:
+		var serverMessage protocol.ServerMessage
+		_ = binary.Read(reader, binary.LittleEndian, &serverMessage)
+		description = fmt.Sprintf("ServerMessage %d %q", serverMessage.Code, body[4:])
+	default:
+		description = fmt.Sprintf("MessageType %d", header.MessageType)
+	}
+
+	return fmt.Sprintf("< seq=%d %s\n< %x%x", header.SequenceNumber, description, tracedHeaderBytes(header), body)
+}
+
+func tracedHeaderBytes(header protocol.MessageHeader) []uint8 {
+	var buff = new(bytes.Buffer)
+	_ = binary.Write(buff, binary.LittleEndian, header)
+	return buff.Bytes()
+}
+
+// replayScript sends each command followed by a ping and records every message up to the pong.
+// The pong works as a barrier and is not recorded since it carries the server time.
+func replayScript(t *testing.T, filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("error opening %s: %s", filename, err)
+	}
+	defer f.Close()
+
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var trace = new(strings.Builder)
+	var scanner = bufio.NewScanner(f)
+	var lineNumber = 0
+
+	for scanner.Scan() {
+		lineNumber++
+		var line = scanner.Text()
+		command, err := parseCommandLine(line)
+		if err != nil {
+			t.Fatalf("%s:%d: %s", filename, lineNumber, err)
+		}
+		if command == nil {
+			continue
+		}
+
+		fmt.Fprintf(trace, "> %s\n", strings.TrimSpace(line))
+		cc.send(append(command, makePing(0)...), math.MaxInt32)
+
+		for {
+			var header protocol.MessageHeader
+			_ = binary.Read(bytes.NewReader(cc.read(int(protocol.MessageHeaderSize))), binary.LittleEndian, &header)
+			var body = cc.read(int(header.BodySize))
+
+			if header.MessageType == protocol.MsgTypePong {
+				break
+			}
+
+			fmt.Fprintln(trace, formatServerMessage(header, body))
+		}
+	}
+
+	return trace.String()
+}
+
+// TestRegressionSnapshots replays the scripts in testdata/snapshots and compares the responses with the ones recorded
+// from this server. They catch unintended changes, they don't prove compatibility with any client.
+func TestRegressionSnapshots(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "snapshots", "*.script"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no scripts found: %v", err)
+	}
+
+	for _, scriptFile := range files {
+		var snapshotFile = strings.TrimSuffix(scriptFile, ".script") + ".snapshot"
+
+		t.Run(filepath.Base(scriptFile), func(t *testing.T) {
+			var trace = replayScript(t, scriptFile)
+
+			if *updateSnapshots {
+				err := ioutil.WriteFile(snapshotFile, []uint8(trace), 0644)
+				if err != nil {
+					t.Fatalf("error writing %s: %s", snapshotFile, err)
+				}
+				return
+			}
+
+			expected, err := ioutil.ReadFile(snapshotFile)
+			if err != nil {
+				t.Fatalf("error reading %s (run with -update to create it): %s", snapshotFile, err)
+			}
+
+			var expectedLines = strings.Split(string(expected), "\n")
+			var traceLines = strings.Split(trace, "\n")
+			for i := 0; i < len(expectedLines) || i < len(traceLines); i++ {
+				var expectedLine, traceLine string
+				if i < len(expectedLines) {
+					expectedLine = expectedLines[i]
+				}
+				if i < len(traceLines) {
+					traceLine = traceLines[i]
+				}
+				if expectedLine != traceLine {
+					t.Fatalf("%s:%d differs\nexpected: %s\nreceived: %s", snapshotFile, i+1, expectedLine, traceLine)
+				}
+			}
+		})
+	}
+}
@@ -1,60 +1,116 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/racerxdl/radioserver/SLog"
 	"github.com/racerxdl/radioserver/StateModels"
-	"github.com/racerxdl/radioserver/protocol"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
 var tcpSlog = SLog.Scope("TCP Server")
 var tcpServerStatus = false
-var listenPort = protocol.DefaultPort
+var serverConfig = DefaultServerConfig()
+
+// serverState is the default frontend, used by listeners that don't select one
 var serverState = StateModels.CreateServerState()
 
-const defaultReadTimeout = 1000
+// serverStates are all the frontends in the configuration order, serverState is the first one
+var serverStates = []*StateModels.ServerState{serverState}
+var globalGuard *connectionGuard
+
+const defaultReadTimeout = 1000 * time.Millisecond
+const tlsHandshakeTimeout = 10 * time.Second
 
 func parseHttpError(err error, state *StateModels.ClientState) {
 	if err.Error() == "EOF" {
-		state.Running = false
+		state.Disconnect("connection closed by client")
 		return
 	}
 
 	switch e := err.(type) {
 	case net.Error:
 		if !e.Timeout() {
-			if tcpServerStatus && state.Running {
+			if tcpServerStatus && state.IsRunning() {
 				state.Error("Error receiving data: %s", e)
 			}
-			state.Running = false
+			state.Disconnect(fmt.Sprintf("read error: %s", e))
 		}
 	default:
-		if tcpServerStatus && state.Running {
+		if tcpServerStatus && state.IsRunning() {
 			state.Error("Error receiving data: %s", e)
 		}
-		state.Running = false
+		state.Disconnect(fmt.Sprintf("read error: %s", e))
+	}
+}
+
+// findServerState returns the frontend with the specified name, or the default one if name is empty
+func findServerState(name string) *StateModels.ServerState {
+	if name == "" {
+		return serverState
 	}
+
+	for _, s := range serverStates {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
 }
 
-func handleConnection(c net.Conn) {
-	var clientState = StateModels.CreateClientState(serverState.Frontend.GetCenterFrequency())
+func createClientState(c net.Conn, s *StateModels.ServerState) *StateModels.ClientState {
+	var clientState = StateModels.CreateClientState(s.DeviceCenterFrequency())
 
 	clientState.Addr = c.RemoteAddr()
-	clientState.LogInstance = SLog.Scope(fmt.Sprintf("Client %s", c.RemoteAddr()))
+	clientState.LogInstance = SLog.Scope("Client").With("uuid", clientState.UUID).With("address", c.RemoteAddr().String())
+	if s.Name != "" {
+		clientState.LogInstance = clientState.LogInstance.With("frontend", s.Name)
+	}
 	clientState.Conn = c
-	clientState.Running = true
-	clientState.ServerState = serverState
+	clientState.SetRunning(true)
+	clientState.ServerState = s
 	clientState.ServerVersion = ServerVersion
 
-	serverState.PushClient(clientState)
+	return clientState
+}
+
+func handleConnection(c net.Conn, l *serverListener) {
+	var protocolErrors = 0
+	defer func() {
+		l.release(remoteIP(c.RemoteAddr()), protocolErrors)
+	}()
+
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		_ = c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		err := tlsConn.Handshake()
+		if err != nil {
+			tcpSlog.Error("TLS Handshake with %s failed: %s", c.RemoteAddr(), err)
+			protocolErrors = 1
+			c.Close()
+			return
+		}
+		_ = c.SetDeadline(time.Time{})
+	}
+
+	var clientState = createClientState(c, l.serverState())
+	clientState.Bandwidth = l.bandwidthLimits()
+	clientState.Timeouts = serverConfig.Timeouts.ClientTimeouts()
 
-	tcpSlog.Log("New connection from %s", clientState.Addr)
+	clientState.ServerState.PushClient(clientState)
+
+	tcpSlog.Log("New connection from %s at %s", clientState.Addr, l.Addr())
+	auditConnect(clientState, l)
 
 	for {
-		if !tcpServerStatus || !clientState.Running {
+		if !tcpServerStatus {
+			clientState.Disconnect("server shutting down")
+		}
+
+		if !clientState.IsRunning() || !clientState.CheckTimeouts() {
 			break
 		}
 
@@ -65,7 +121,7 @@ func handleConnection(c net.Conn) {
 			parseHttpError(err, clientState)
 		}
 
-		if !clientState.Running {
+		if !clientState.IsRunning() {
 			break
 		}
 
@@ -75,45 +131,58 @@ func handleConnection(c net.Conn) {
 			parseMessage(clientState, sl)
 		}
 	}
+	protocolErrors = clientState.ProtocolErrors
 	clientState.FullStop()
-	serverState.RemoveClient(clientState)
-	tcpSlog.Log("Connection closed from %s", clientState.Addr)
+	clientState.ServerState.RemoveClient(clientState)
+	tcpSlog.Log("Connection closed from %s: %s", clientState.Addr, clientState.GetDisconnectReason())
+	auditDisconnect(clientState)
 	c.Close()
 
 }
 
 func runServer(stopSignal chan bool) {
 	tcpSlog.Info("Starting TCP Server")
-	l, err := net.Listen("tcp4", fmt.Sprintf(":%d", listenPort))
-
+	var err error
+	globalGuard, err = createConnectionGuard(&serverConfig.Limits)
 	if err != nil {
-		tcpSlog.Error("Error listening: %s", err)
+		tcpSlog.Error("Error in limits configuration: %s", err)
 		return
 	}
 
-	defer l.Close()
+	listeners, err := listenAll(serverConfig.GetListeners())
 
-	tcpSlog.Info("Listening at port %d", listenPort)
+	if err != nil {
+		tcpSlog.Error("Error listening: %s", err)
+		return
+	}
 
 	rand.Seed(time.Now().Unix() + rand.Int63() + rand.Int63())
 
 	tcpServerStatus = true
 
+	var closeListeners = func() {
+		tcpServerStatus = false
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+
 	go func() {
 		<-stopSignal
 		tcpSlog.Info("Received stop signal! Closing TCP Server...")
-		l.Close()
+		closeListeners()
 	}()
 
-	for tcpServerStatus {
-		c, err := l.Accept()
-		if err != nil {
-			if tcpServerStatus {
-				tcpSlog.Error("Error accepting client: %s", err)
-			}
-			tcpServerStatus = false
-			break
-		}
-		go handleConnection(c)
+	var wg = sync.WaitGroup{}
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *serverListener) {
+			defer wg.Done()
+			l.acceptLoop()
+			// If one listener fails, bring the others down as well
+			closeListeners()
+		}(l)
 	}
+
+	wg.Wait()
 }
@@ -3,6 +3,7 @@ package tools
 import (
 	"bytes"
 	"encoding/binary"
+	"github.com/racerxdl/radioserver/protocol"
 	"github.com/racerxdl/segdsp/dsp"
 	"math"
 )
@@ -122,12 +123,27 @@ func Complex64ToInt16(samples []complex64) []int16 {
 func Complex64ToUInt8(samples []complex64) []uint8 {
 	var u8samples = make([]uint8, len(samples)*2)
 	for i, v := range samples {
-		u8samples[i*2] = uint8(real(v)*127) + 127
-		u8samples[i*2+1] = uint8(imag(v)*127) + 127
+		u8samples[i*2] = float32ToOffsetUInt8(real(v))
+		u8samples[i*2+1] = float32ToOffsetUInt8(imag(v))
 	}
 	return u8samples
 }
 
+// uint8Zero is the zero of unsigned 8 bit IQ samples. As in librtlsdr it is between 127 and 128, so 0 and 255 are
+// -1 and 1 and both conversions are symmetric.
+const uint8Zero = 127.5
+
+// float32ToOffsetUInt8 converts a sample in the [-1, 1] range to the nearest unsigned 8 bit value, clipping values
+// outside it
+func float32ToOffsetUInt8(v float32) uint8 {
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	return uint8(v*uint8Zero + uint8Zero + 0.5)
+}
+
 // endregion
 // region Float32 to XX Array converters
 func Float32ToInt16(samples []float32) []int16 {
@@ -146,12 +162,73 @@ func Float32ToUInt8(samples []float32) []uint8 {
 	return u8samples
 }
 
+// endregion
+// region Raw IQ to Complex64 converters
+
+// UInt8ToComplex64 converts interleaved unsigned 8 bit IQ samples (127.5 being zero) to complex64
+func UInt8ToComplex64(data []uint8) []complex64 {
+	var samples = make([]complex64, len(data)/2)
+	for i := range samples {
+		samples[i] = complex((float32(data[i*2])-uint8Zero)/uint8Zero, (float32(data[i*2+1])-uint8Zero)/uint8Zero)
+	}
+	return samples
+}
+
+// Int8ToComplex64 converts interleaved signed 8 bit IQ samples to complex64
+func Int8ToComplex64(data []uint8) []complex64 {
+	var samples = make([]complex64, len(data)/2)
+	for i := range samples {
+		samples[i] = complex(float32(int8(data[i*2]))/128, float32(int8(data[i*2+1]))/128)
+	}
+	return samples
+}
+
+// Int16ToComplex64 converts interleaved little endian signed 16 bit IQ samples to complex64
+func Int16ToComplex64(data []uint8) []complex64 {
+	var samples = make([]complex64, len(data)/4)
+	for i := range samples {
+		var re = int16(binary.LittleEndian.Uint16(data[i*4:]))
+		var im = int16(binary.LittleEndian.Uint16(data[i*4+2:]))
+		samples[i] = complex(float32(re)/32768, float32(im)/32768)
+	}
+	return samples
+}
+
+// Float32ToComplex64 converts interleaved little endian float32 IQ samples to complex64
+func Float32ToComplex64(data []uint8) []complex64 {
+	var samples = make([]complex64, len(data)/8)
+	for i := range samples {
+		var re = math.Float32frombits(binary.LittleEndian.Uint32(data[i*8:]))
+		var im = math.Float32frombits(binary.LittleEndian.Uint32(data[i*8+4:]))
+		samples[i] = complex(re, im)
+	}
+	return samples
+}
+
 // endregion
 
 func StageToNumber(stage uint32) uint32 {
 	return uint32(math.Pow(2, float64(stage)))
 }
 
+// IQFormatSampleSize returns the size in bytes of a complex sample in the specified stream format
+func IQFormatSampleSize(format uint32) uint32 {
+	switch format {
+	case protocol.StreamFormatUint8:
+		return 2
+	case protocol.StreamFormatInt16:
+		return 4
+	case protocol.StreamFormatInt24:
+		return 6
+	case protocol.StreamFormatFloat:
+		return 8
+	case protocol.StreamFormatDint4:
+		return 1
+	}
+
+	return 0
+}
+
 func GenerateTranslatorTaps(decimation, sampleRate uint32) []float32 {
 	var outputSampleRate = float64(sampleRate)
 	return dsp.MakeLowPassFixed(1, outputSampleRate, outputSampleRate/(2*float64(decimation)), 31)
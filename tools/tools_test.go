@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"testing"
+)
+
+func TestComplex64ToUInt8(t *testing.T) {
+	// 127.5 is zero and the scale is 127.5, rounded to the nearest value
+	var converted = map[float32]uint8{
+		-1:   0,
+		-0.5: 64,
+		0:    128,
+		0.5:  191,
+		1:    255,
+	}
+
+	for v, expected := range converted {
+		var out = Complex64ToUInt8([]complex64{complex(v, -v)})
+		var expectedImag = converted[-v]
+		if out[0] != expected || out[1] != expectedImag {
+			t.Errorf("%v: expected %d, %d got %d, %d", v, expected, expectedImag, out[0], out[1])
+		}
+	}
+
+	// Samples outside [-1, 1] are clipped instead of wrapping around
+	var clipped = map[float32]uint8{
+		-3:   0,
+		-1.5: 0,
+		1.5:  255,
+		3:    255,
+	}
+
+	for v, expected := range clipped {
+		var out = Complex64ToUInt8([]complex64{complex(v, 0)})
+		if out[0] != expected {
+			t.Errorf("%v: expected %d got %d", v, expected, out[0])
+		}
+	}
+}
+
+func TestUInt8RoundTrip(t *testing.T) {
+	var data = make([]uint8, 256)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+
+	var samples = UInt8ToComplex64(data)
+	if real(samples[0]) != -1 || imag(samples[127]) != 1 {
+		t.Errorf("expected 0 and 255 to be -1 and 1 got %v and %v", real(samples[0]), imag(samples[127]))
+	}
+
+	// No DC offset: opposite values are symmetric around zero
+	for i := 0; i < 128; i++ {
+		var pair = UInt8ToComplex64([]uint8{uint8(i), uint8(255 - i)})[0]
+		if real(pair) != -imag(pair) {
+			t.Errorf("%d and %d are not symmetric: %v", i, 255-i, pair)
+		}
+	}
+
+	var out = Complex64ToUInt8(samples)
+	for i := range data {
+		if out[i] != data[i] {
+			t.Errorf("%d: round trip gave %d", data[i], out[i])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/racerxdl/radioserver/StateModels"
+	"github.com/racerxdl/radioserver/frontends"
+	"github.com/racerxdl/radioserver/protocol"
+	"io/ioutil"
+	"math"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFrontendWatchdog(t *testing.T) {
+	var cc = startConformanceClient(t)
+	defer cc.close()
+
+	var model = createConformanceModel()
+	cc.send(makeHello("Conformance"), math.MaxInt32)
+	cc.expect(model.hello())
+
+	cc.send(makeSetSetting(protocol.SettingGain, 5), math.MaxInt32)
+	model.gain = 5
+	cc.expect(model.sync())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverState.StartWatchdog(ctx, 100*time.Millisecond)
+
+	// The mock frontend never sends samples
+	var lostMessage = "Frontend stopped sending samples. Trying to recover it..."
+	cc.expect(makeMessage(protocol.MsgTypeServerMessage, protocol.StreamTypeStatus, model.nextSequence(), append(uint32sToBytes(protocol.ServerMessageFrontendLost), []uint8(lostMessage)...)))
+	cc.expect(expectedDeviceInfo(model.nextSequence(), mockSampleRate))
+	cc.expect(model.sync())
+	cc.expect(makeMessage(protocol.MsgTypeServerMessage, protocol.StreamTypeStatus, model.nextSequence(), append(uint32sToBytes(protocol.ServerMessageFrontendRecovered), []uint8("Frontend recovered")...)))
+
+	// Still no samples, so stop the watchdog before it re-opens the frontend again
+	cancel()
+
+	var initCount, destroyCount = cc.frontend.openCounts()
+	if destroyCount == 0 || initCount == 0 {
+		t.Errorf("expected the frontend to be re-opened (destroy %d, init %d)", destroyCount, initCount)
+	}
+
+	if running, gain := cc.frontend.isRunning(), cc.frontend.GetGain(); !running || gain != 5 {
+		t.Errorf("expected the frontend running with gain 5 got running %t gain %d", running, gain)
+	}
+}
+
+func TestFrontendUnavailable(t *testing.T) {
+	var frontend = createMockFrontend()
+	var s = StateModels.CreateServerState()
+	s.Frontend = frontend
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frontend.initErr = errors.New("no device found")
+	if s.OpenFrontend(ctx, nil, time.Hour) {
+		t.Fatalf("expected the frontend to fail to open")
+	}
+	if s.FrontendUnavailable() == nil {
+		t.Fatalf("expected the frontend to be unavailable")
+	}
+
+	// New clients are told why they can't connect
+	var l = &serverListener{
+		Listener: pipeListener{},
+		config:   &ListenerConfig{},
+	}
+	server, client := net.Pipe()
+	go l.rejectUnavailable(server, s.FrontendUnavailable())
+
+	var data, _ = ioutil.ReadAll(client)
+	var message = "Frontend unavailable: no device found"
+	var expected = makeMessage(protocol.MsgTypeServerMessage, protocol.StreamTypeStatus, 0, append(uint32sToBytes(protocol.ServerMessageRejected), []uint8(message)...))
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected %x got %x", expected, data)
+	}
+
+	// The device shows up later
+	var plugged int32
+	var s2 = StateModels.CreateServerState()
+	s2.Frontend = createMockFrontend()
+	var setup = func(frontends.Frontend) error {
+		if atomic.LoadInt32(&plugged) == 0 {
+			return errors.New("no device found")
+		}
+		return nil
+	}
+
+	if s2.OpenFrontend(ctx, setup, 10*time.Millisecond) {
+		t.Fatalf("expected the frontend to fail to open")
+	}
+
+	atomic.StoreInt32(&plugged, 1)
+	var deadline = time.Now().Add(time.Second)
+	for s2.FrontendUnavailable() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the frontend to be opened again got %s", s2.FrontendUnavailable())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := s2.LastFrontendError(); err == nil || err.Error() != "no device found" {
+		t.Errorf("expected the last error to be kept got %v", err)
+	}
+}